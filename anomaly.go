@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+const (
+	// minBaselineDays is the number of days with values needed for a
+	// baseline.
+	minBaselineDays = 3
+	// madScale scales the median absolute deviation to the standard deviation
+	// of normally distributed values.
+	madScale = 1.4826
+)
+
+// checkAnomaly compares the mean of the current interval to the same interval
+// of the previous days. The warning and error levels are the number of
+// standard deviations the current value may deviate from the baseline.
+// Every day is fetched on its own, as a single request over all days would be
+// answered at the resolution of the oldest day, which is often coarser than
+// the interval.
+func checkAnomaly(ctx context.Context, backend Backend, opts *checkOptions) monzero.CheckResult {
+	result := monzero.CheckResult{ExitCode: 3}
+	if opts.days < minBaselineDays {
+		result.Message = fmt.Sprintf("days must be at least %d", minBaselineDays)
+		return result
+	}
+	window, err := parseInterval(opts.interval)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	target, err := renderTarget(backend, opts)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	traceFrom(ctx).Target = target
+
+	now := time.Now()
+	current, err := backend.Fetch(ctx, target, opts.interval)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	days := make([][]Series, opts.days)
+	for day := range days {
+		end := now.Add(-time.Duration(day+1) * 24 * time.Hour)
+		days[day], err = backend.FetchRange(ctx, target, end.Add(-window), end)
+		if err != nil {
+			result.Message = fmt.Sprintf("could not get the baseline of %d days ago: %s", day+1, err)
+			return result
+		}
+	}
+	return anomalyResult(ctx, current, days, opts)
+}
+
+// anomalyResult checks the current series against the baseline of the series
+// of the previous days, starting with yesterday.
+func anomalyResult(ctx context.Context, current []Series, days [][]Series, opts *checkOptions) monzero.CheckResult {
+	result := monzero.CheckResult{ExitCode: 3}
+	value, found := windowMean(current)
+	if !found {
+		result.ExitCode = 2
+		result.Message = "No values received for query! Is the host down?"
+		return result
+	}
+	traceFrom(ctx).Value = &value
+
+	baseline := []float64{}
+	for _, series := range days {
+		if mean, found := windowMean(series); found {
+			baseline = append(baseline, mean)
+		}
+	}
+	if len(baseline) < minBaselineDays {
+		result.Message = fmt.Sprintf("only %d of %d days have values, not enough for a baseline", len(baseline), len(days))
+		return result
+	}
+
+	center, sigma, err := baselineStats(baseline, opts.baseline)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	deviation := 0.0
+	switch {
+	case sigma > 0:
+		deviation = (value - center) / sigma
+	case value != center:
+		deviation = math.Copysign(math.Inf(1), value-center)
+	}
+	distance := math.Abs(deviation)
+	result.ExitCode, _ = evaluate([]Series{{Points: []Point{{Value: &distance}}}}, opts.levelWarn, opts.levelErr)
+
+	lower, upper := center-opts.levelWarn*sigma, center+opts.levelWarn*sigma
+	msg := strings.Builder{}
+	fmt.Fprintf(&msg, opts.message+"\n", value)
+	fmt.Fprintf(&msg, "expected %g to %g, %s %g of %d days, deviation %.2f sigma",
+		lower, upper, opts.baseline, center, len(baseline), deviation)
+	fmt.Fprintf(&msg, " | value=%g lower=%g upper=%g deviation=%.2f;%g;%g\n",
+		value, lower, upper, deviation, opts.levelWarn, opts.levelErr)
+	result.Message = msg.String()
+	return result
+}
+
+// windowMean returns the mean of all values of the series.
+func windowMean(series []Series) (float64, bool) {
+	sum, count := 0.0, 0
+	for _, s := range series {
+		for _, point := range s.Points {
+			if point.Value != nil {
+				sum += *point.Value
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+// baselineStats returns the center and the standard deviation of the values.
+// With the median method, these are the median and the scaled median absolute
+// deviation, which are not moved by single outliers like holidays.
+func baselineStats(values []float64, method string) (float64, float64, error) {
+	switch method {
+	case "median":
+		center := median(values)
+		deviations := make([]float64, len(values))
+		for i, value := range values {
+			deviations[i] = math.Abs(value - center)
+		}
+		return center, madScale * median(deviations), nil
+	case "mean":
+		series := make([]Series, len(values))
+		for i := range values {
+			series[i] = Series{Points: []Point{{Value: &values[i]}}}
+		}
+		mean, stddev, _ := meanStddev(series)
+		return mean, stddev, nil
+	default:
+		return 0, 0, fmt.Errorf("unknown baseline '%s'", method)
+	}
+}
+
+// median returns the median of the values.
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+// dailySeries returns one series per day with a single point, starting with
+// yesterday. Negative values are returned as null.
+func dailySeries(now time.Time, values ...float64) [][]Series {
+	days := make([][]Series, len(values))
+	for i, value := range values {
+		days[i] = pointSeries(now.Add(-time.Duration(i+1)*24*time.Hour-30*time.Second), value)
+	}
+	return days
+}
+
+// pointSeries returns a series with a single point at the time. A negative
+// value is returned as null.
+func pointSeries(at time.Time, value float64) []Series {
+	point := Point{Timestamp: at.Unix()}
+	if value >= 0 {
+		v := value
+		point.Value = &v
+	}
+	return []Series{{Name: "requests", Points: []Point{point}}}
+}
+
+func TestAnomalyResult(t *testing.T) {
+	now := time.Unix(100*24*3600, 0)
+	// The baseline has a median of 10 and a median absolute deviation of 1.
+	history := []float64{10, 12, 11, 9, 10, 11, 10}
+	tests := []struct {
+		name     string
+		current  float64
+		baseline string
+		history  []float64
+		code     int
+		message  string
+	}{
+		{name: "within the band", current: 13, baseline: "median", history: history, code: 0},
+		{name: "above the band", current: 15, baseline: "median", history: history, code: 1},
+		{name: "below the band", current: 5, baseline: "median", history: history, code: 1},
+		{name: "far above the band", current: 20, baseline: "median", history: history, code: 2,
+			message: "current value: 20.000000\nexpected 5.5522 to 14.4478, median 10 of 7 days, deviation 6.74 sigma | value=20 lower=5.5522 upper=14.4478 deviation=6.74;3;5\n"},
+		{name: "mean baseline", current: 20, baseline: "mean", history: history, code: 2},
+		{name: "no deviation in the baseline", current: 11, baseline: "median", history: []float64{10, 10, 10, 10}, code: 2},
+		{name: "missing days", current: 10, baseline: "median", history: []float64{-1, -1, 10, -1, 10, -1, -1}, code: 3,
+			message: "only 2 of 7 days have values, not enough for a baseline"},
+		{name: "no current value", current: -1, baseline: "median", history: history, code: 2,
+			message: "No values received for query! Is the host down?"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &checkOptions{levelWarn: 3, levelErr: 5, days: 7, baseline: test.baseline, message: "current value: %f"}
+			current := pointSeries(now.Add(-30*time.Second), test.current)
+			result := anomalyResult(context.Background(), current, dailySeries(now, test.history...), opts)
+			if result.ExitCode != test.code {
+				t.Errorf("got exit code %d, expected %d with message %q", result.ExitCode, test.code, result.Message)
+			}
+			if test.message != "" && result.Message != test.message {
+				t.Errorf("got message %q, expected %q", result.Message, test.message)
+			}
+		})
+	}
+}
+
+func TestAnomalyRequest(t *testing.T) {
+	g := newFakeGraphite(t, ok("requests", 1.0))
+	r := &runner{client: g.Client()}
+	command := []string{"check_graphite", "-addr", g.URL, "-key", "requests", "-mode", "anomaly", "-days", "3", "-warn", "3", "-error", "5"}
+	r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	received := g.received()
+	if len(received) != 4 {
+		t.Fatalf("got %d requests, expected the current window and 3 days", len(received))
+	}
+	if from := received[0].Query().Get("from"); from != "-60s" {
+		t.Errorf("got from=%s, expected the interval for the current window", from)
+	}
+	for day, u := range received[1:] {
+		from, _ := strconv.ParseInt(u.Query().Get("from"), 10, 64)
+		until, _ := strconv.ParseInt(u.Query().Get("until"), 10, 64)
+		ago := time.Since(time.Unix(until, 0)).Round(time.Hour)
+		if until-from != 60 || ago != time.Duration(day+1)*24*time.Hour {
+			t.Errorf("got from=%d until=%d for day %d, expected the interval %d days ago", from, until, day+1, day+1)
+		}
+	}
+	for _, u := range received {
+		if !strings.HasPrefix(u.Path, "/render") {
+			t.Errorf("got path %s, expected the render api", u.Path)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Backend fetches series from a metrics store.
+	Backend interface {
+		// Fetch returns all series matching target over the last interval.
+		Fetch(ctx context.Context, target, interval string) ([]Series, error)
+		// FetchRange returns all series matching target from the time from
+		// up to until.
+		FetchRange(ctx context.Context, target string, from, until time.Time) ([]Series, error)
+		// TagTarget builds a target selecting all series matching the tag
+		// expressions, like name=cpu.load or dc!=fra.
+		TagTarget(exprs []string) (string, error)
+	}
+
+	// Series is a named list of datapoints as returned by a backend.
+	Series struct {
+		Name   string            `json:"name"`
+		Tags   map[string]string `json:"tags,omitempty"`
+		Points []Point           `json:"points"`
+	}
+
+	// Point is a single datapoint of a series. Value is nil when the backend
+	// has no data for the timestamp.
+	Point struct {
+		Timestamp int64    `json:"timestamp"`
+		Value     *float64 `json:"value"`
+	}
+)
+
+// newBackend returns the backend with the given name talking to the endpoints
+// of the pool.
+func newBackend(name string, r *runner, pool *endpointPool, retries int) (Backend, error) {
+	switch name {
+	case "", "graphite":
+		return newGraphite(r, pool, retries), nil
+	case "prometheus":
+		return newPrometheus(r, pool, retries), nil
+	default:
+		return nil, fmt.Errorf("unknown backend '%s'", name)
+	}
+}
+
+// get returns the content of the body of the url on the endpoint. When a cache
+// is configured, the response is taken from or stored in it.
+func (r *runner) get(ctx context.Context, e *endpoint, api, url string, retries int) ([]byte, error) {
+	if r.cache == nil {
+		return r.fetch(ctx, e, api, url, "", retries)
+	}
+	return r.cache.get(ctx, e.name, url, func() ([]byte, error) {
+		return r.fetch(ctx, e, api, url, "", retries)
+	})
+}
+
+// fetch requests the url with the client and headers of the endpoint and
+// returns the content of the body. When form is not empty, it is posted as the
+// body of the request. The api name is used in the error messages. Requests
+// answered with a status code above 500 are retried up to retries times.
+func (r *runner) fetch(ctx context.Context, e *endpoint, api, url, form string, retries int) ([]byte, error) {
+	client := r.client
+	if e.client != nil {
+		client = e.client
+	}
+	var (
+		res *http.Response
+		raw []byte
+	)
+	request := traceRequest{URL: url}
+	defer func(start time.Time) {
+		request.Duration = time.Since(start)
+		traceFrom(ctx).addRequest(request)
+	}(time.Now())
+
+	for i := 0; i < retries+1; i++ {
+		request.Attempts++
+		method, body := http.MethodGet, io.Reader(nil)
+		if form != "" {
+			method, body = http.MethodPost, strings.NewReader(form)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		if err != nil {
+			return nil, fmt.Errorf("could not create request: %s", err)
+		}
+		for name, values := range e.header {
+			req.Header[name] = values
+		}
+		if form != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		queued := time.Now()
+		release, err := e.limiter.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		request.Wait += start.Sub(queued)
+		res, err = client.Do(req)
+		if err != nil {
+			release()
+			stats.responses.Inc("error")
+			return nil, &unavailableError{fmt.Errorf("could not get result: %s", err)}
+		}
+		// The request takes its slot until the whole body is read.
+		raw, err = io.ReadAll(res.Body)
+		res.Body.Close()
+		release()
+		if err != nil {
+			return nil, &unavailableError{fmt.Errorf("could not read content body: %s", err)}
+		}
+		stats.renders.Observe(time.Since(start))
+		stats.responses.Inc(strconv.Itoa(res.StatusCode))
+		request.StatusCode = res.StatusCode
+		request.Size = len(raw)
+
+		// For some reason metrictank is unable to return any data when it goes into
+		// maintenance mode. There is no way to work around the issue, because of
+		// its architecture.
+		// So when it is not in the mood to return data, we just retry again.
+		if res.StatusCode > 500 {
+			if i < retries {
+				stats.retries.Inc("")
+			}
+			continue
+		}
+		if res.StatusCode == http.StatusInternalServerError {
+			return nil, &unavailableError{fmt.Errorf("%s api answered with status code %d", api, res.StatusCode)}
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, &answeredError{fmt.Errorf("%s api answered with status code %d", api, res.StatusCode)}
+		}
+		traceFrom(ctx).setBody(raw)
+		return raw, nil
+	}
+	return nil, &unavailableError{fmt.Errorf("%s api has internal problems, answered with status code: %d", api, res.StatusCode)}
+}
+
+// intervalUnits are the prefixes by which graphite matches the units of
+// relative times, in the order graphite tries them, with their duration.
+var intervalUnits = []struct {
+	prefix   string
+	duration time.Duration
+}{
+	{"s", time.Second},
+	{"min", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+	{"mon", 30 * 24 * time.Hour},
+	{"m", time.Minute},
+	{"y", 365 * 24 * time.Hour},
+}
+
+// parseInterval converts an interval in the graphite notation, like 60s, 5min,
+// 1hr or 7d, into a duration. Like graphite, units are matched by their
+// prefix.
+func parseInterval(interval string) (time.Duration, error) {
+	i := strings.IndexFunc(interval, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return 0, fmt.Errorf("could not parse interval '%s'", interval)
+	}
+	num, err := strconv.Atoi(interval[:i])
+	if err != nil {
+		return 0, fmt.Errorf("could not parse interval '%s': %s", interval, err)
+	}
+	unit := interval[i:]
+	for _, u := range intervalUnits {
+		if strings.HasPrefix(unit, u.prefix) {
+			return time.Duration(num) * u.duration, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown unit in interval '%s'", interval)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+type (
+	// backtestResult summarizes how a check would have behaved over the
+	// history of its series.
+	backtestResult struct {
+		From        time.Time
+		To          time.Time
+		Evaluations int
+		// Transitions counts the changes into each state, indexed by the exit
+		// code.
+		Transitions [4]int
+		// Duration is the time spent in each state, indexed by the exit code.
+		Duration [4]time.Duration
+		// NoData is the time in which the window contained no point at all,
+		// which is not evaluated. Null points are evaluated like the check
+		// does.
+		NoData    time.Duration
+		Incidents []incident
+	}
+
+	// incident is a period in which the check was not ok.
+	incident struct {
+		Start time.Time
+		End   time.Time
+		State int // State is the worst state during the incident
+	}
+)
+
+// backtest slides the window of the check over the series in steps and
+// evaluates every window like a run of the check would.
+func backtest(series []Series, opts *checkOptions, window, step time.Duration) backtestResult {
+	result := backtestResult{}
+	prev := 0
+	var current *incident
+	from, to, found := eachWindow(series, window, step, func(end time.Time, windowed []Series) {
+		if !hasPoints(windowed) {
+			result.NoData += step
+			return
+		}
+		state := renderResult(context.Background(), windowed, opts).ExitCode
+		result.Evaluations++
+		result.Duration[state] += step
+		if state != prev && state != 0 {
+			result.Transitions[state]++
+		}
+		switch {
+		case state != 0 && current == nil:
+			current = &incident{Start: end, State: state}
+		case state == 0 && current != nil:
+			current.End = end
+			result.Incidents = append(result.Incidents, *current)
+			current = nil
+		}
+		if current != nil && state > current.State {
+			current.State = state
+		}
+		prev = state
+	})
+	if !found {
+		return result
+	}
+	result.From, result.To = from, to
+	if current != nil {
+		current.End = result.To
+		result.Incidents = append(result.Incidents, *current)
+	}
+	return result
+}
+
+// eachWindow calls fn for every window of the series, moving the end of the
+// window in steps from the first point plus the window to the last point. It
+// returns the times of the first and the last point and false when the series
+// have no points.
+func eachWindow(series []Series, window, step time.Duration, fn func(end time.Time, windowed []Series)) (time.Time, time.Time, bool) {
+	found := false
+	first, last := int64(0), int64(0)
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		if start := s.Points[0].Timestamp; !found || start < first {
+			first = start
+		}
+		if end := s.Points[len(s.Points)-1].Timestamp; !found || end > last {
+			last = end
+		}
+		found = true
+	}
+	if !found {
+		return time.Time{}, time.Time{}, false
+	}
+	from, to := time.Unix(first, 0).UTC(), time.Unix(last, 0).UTC()
+	for end := from.Add(window); !end.After(to); end = end.Add(step) {
+		fn(end, windowSeries(series, end.Add(-window).Unix(), end.Unix()))
+	}
+	return from, to, true
+}
+
+// windowSeries returns the series cut down to the points after from up to and
+// including to. The points of the series must be sorted by time.
+func windowSeries(series []Series, from, to int64) []Series {
+	result := make([]Series, len(series))
+	for i, s := range series {
+		start := sort.Search(len(s.Points), func(j int) bool { return s.Points[j].Timestamp > from })
+		end := sort.Search(len(s.Points), func(j int) bool { return s.Points[j].Timestamp > to })
+		result[i] = Series{Name: s.Name, Tags: s.Tags, Points: s.Points[start:end]}
+	}
+	return result
+}
+
+// hasPoints returns true when any of the series has a point, even a null one.
+func hasPoints(series []Series) bool {
+	for _, s := range series {
+		if len(s.Points) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// seriesResolution returns the time between two points of the series, which
+// is the smallest distance of two points in the coarsest series. It is 0 when
+// no series has two points.
+func seriesResolution(series []Series) time.Duration {
+	resolution := int64(0)
+	for _, s := range series {
+		smallest := int64(0)
+		for i := 1; i < len(s.Points); i++ {
+			if d := s.Points[i].Timestamp - s.Points[i-1].Timestamp; d > 0 && (smallest == 0 || d < smallest) {
+				smallest = d
+			}
+		}
+		if smallest > resolution {
+			resolution = smallest
+		}
+	}
+	return time.Duration(resolution) * time.Second
+}
+
+// historyWindow returns the window to evaluate the history with. A long
+// history is often returned at a coarser resolution than a run of the check
+// gets, so a window shorter than the resolution is widened to it. Otherwise
+// most windows would contain no point. When the window was widened, a note is
+// written to w.
+func historyWindow(series []Series, window time.Duration, w io.Writer) time.Duration {
+	resolution := seriesResolution(series)
+	if window >= resolution {
+		return window
+	}
+	fmt.Fprintf(w, "the history has a resolution of %s, the window is widened from %s to it\n", resolution, window)
+	return resolution
+}
+
+// fetchHistory returns the series of the check over the span. When the check
+// replays a recording, the series of the recorded response are returned.
+func (r *runner) fetchHistory(ctx context.Context, opts *checkOptions, span string) ([]Series, error) {
+	if opts.replay != "" {
+		rec, err := loadRecording(opts.replay)
+		if err != nil {
+			return nil, err
+		}
+		return rec.series()
+	}
+	backend, err := r.prepare(opts)
+	if err != nil {
+		return nil, err
+	}
+	target, err := renderTarget(backend, opts)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Fetch(ctx, target, span)
+}
+
+// runBacktest fetches the history of the check once and writes the result of
+// the backtest to w.
+func (r *runner) runBacktest(ctx context.Context, opts *checkOptions, span string, step time.Duration, w io.Writer) error {
+	window, err := parseInterval(opts.interval)
+	if err != nil {
+		return err
+	}
+	if step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	series, err := r.fetchHistory(ctx, opts, span)
+	if err != nil {
+		return err
+	}
+	window = historyWindow(series, window, w)
+	result := backtest(series, opts, window, step)
+	if result.Evaluations == 0 {
+		return fmt.Errorf("not enough data for a window of %s", opts.interval)
+	}
+
+	fmt.Fprintf(w, "%d evaluations from %s to %s, window %s, step %s\n",
+		result.Evaluations, result.From.Format(time.RFC3339), result.To.Format(time.RFC3339), window, step)
+	for state := 1; state <= 3; state++ {
+		fmt.Fprintf(w, "%s: %d times, %s in total\n", stateName(state), result.Transitions[state], result.Duration[state])
+	}
+	if result.NoData > 0 {
+		fmt.Fprintf(w, "no data: %s in total\n", result.NoData)
+	}
+	for _, inc := range result.Incidents {
+		fmt.Fprintf(w, "%s %s - %s (%s)\n", stateName(inc.State), inc.Start.Format(time.RFC3339), inc.End.Format(time.RFC3339), inc.End.Sub(inc.Start))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSeries returns a series with a point every minute starting at 0. Negative
+// values are returned as null.
+func testSeries(values ...float64) Series {
+	s := Series{Name: "a.b"}
+	for i, value := range values {
+		point := Point{Timestamp: int64(60 * i)}
+		if value >= 0 {
+			v := value
+			point.Value = &v
+		}
+		s.Points = append(s.Points, point)
+	}
+	return s
+}
+
+func TestBacktest(t *testing.T) {
+	series := []Series{testSeries(0, 0, 10, 10, 0, 0, 20, 0)}
+	opts := &checkOptions{levelWarn: 5, levelErr: 15, message: "current value: %f"}
+	result := backtest(series, opts, time.Minute, time.Minute)
+
+	if result.Evaluations != 7 {
+		t.Errorf("got %d evaluations, expected 7", result.Evaluations)
+	}
+	if result.Transitions[1] != 1 || result.Transitions[2] != 1 {
+		t.Errorf("got transitions %v, expected one warning and one error", result.Transitions)
+	}
+	if result.Duration[1] != 2*time.Minute || result.Duration[2] != time.Minute {
+		t.Errorf("got durations %v", result.Duration)
+	}
+	expected := []incident{
+		{Start: time.Unix(120, 0).UTC(), End: time.Unix(240, 0).UTC(), State: 1},
+		{Start: time.Unix(360, 0).UTC(), End: time.Unix(420, 0).UTC(), State: 2},
+	}
+	if len(result.Incidents) != len(expected) {
+		t.Fatalf("got incidents %v, expected %v", result.Incidents, expected)
+	}
+	for i := range expected {
+		if result.Incidents[i] != expected[i] {
+			t.Errorf("got incident %v, expected %v", result.Incidents[i], expected[i])
+		}
+	}
+}
+
+func TestBacktestMissingData(t *testing.T) {
+	series := []Series{testSeries(0, -1, 0)}
+	opts := &checkOptions{levelWarn: 5, levelErr: 15, message: "current value: %f"}
+	result := backtest(series, opts, time.Minute, time.Minute)
+	// A window without values is critical, like a run of the check.
+	if result.Transitions[2] != 1 || result.Duration[2] != time.Minute {
+		t.Errorf("got transitions %v and durations %v", result.Transitions, result.Duration)
+	}
+}
+
+func TestBacktestCoarseHistory(t *testing.T) {
+	// A history at a resolution of five minutes, like a rollup archive.
+	s := testSeries(0, 0, 10, 0)
+	for i := range s.Points {
+		s.Points[i].Timestamp *= 5
+	}
+	series := []Series{s}
+	if resolution := seriesResolution(series); resolution != 5*time.Minute {
+		t.Fatalf("got resolution %s, expected 5m", resolution)
+	}
+
+	report := &strings.Builder{}
+	window := historyWindow(series, time.Minute, report)
+	if window != 5*time.Minute || !strings.Contains(report.String(), "widened from 1m0s") {
+		t.Errorf("got window %s and report %q", window, report)
+	}
+	opts := &checkOptions{levelWarn: 5, levelErr: 15, message: "current value: %f"}
+	result := backtest(series, opts, window, time.Minute)
+	if result.Transitions[2] != 0 || result.Duration[2] != 0 || result.NoData != 0 {
+		t.Errorf("got transitions %v, durations %v and %s without data", result.Transitions, result.Duration, result.NoData)
+	}
+	if result.Transitions[1] != 1 || result.Duration[1] != 5*time.Minute {
+		t.Errorf("got transitions %v and durations %v, expected a warning for 5m", result.Transitions, result.Duration)
+	}
+}
+
+func TestBacktestNoData(t *testing.T) {
+	// The series has no points between 60s and 240s.
+	s := testSeries(0, 0, 0, 0, 0)
+	s.Points = append(s.Points[:2], s.Points[4:]...)
+	opts := &checkOptions{levelWarn: 5, levelErr: 15, message: "current value: %f"}
+	result := backtest([]Series{s}, opts, time.Minute, time.Minute)
+	if result.Duration[2] != 0 || result.NoData != 2*time.Minute || result.Evaluations != 2 {
+		t.Errorf("got %d evaluations, durations %v and %s without data", result.Evaluations, result.Duration, result.NoData)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchPrefix marks the series of a target in a batched render request. The
+// index of the target follows the prefix.
+const batchPrefix = "_cgbatch"
+
+type (
+	// renderBatcher collects the render requests of checks due at the same
+	// time against the same server and interval and sends them as a single
+	// request with multiple targets.
+	renderBatcher struct {
+		window     time.Duration
+		maxTargets int
+		timeout    time.Duration
+		mu         sync.Mutex
+		pending    map[string]*renderBatch
+	}
+
+	// renderBatch is a set of targets waiting to be sent together.
+	renderBatch struct {
+		g        *graphite
+		interval string
+		targets  []string
+		waiters  []chan batchResult
+	}
+
+	batchResult struct {
+		series   []Series
+		err      error
+		requests []traceRequest
+		endpoint string
+	}
+)
+
+func newRenderBatcher(window time.Duration, maxTargets int, timeout time.Duration) *renderBatcher {
+	return &renderBatcher{
+		window:     window,
+		maxTargets: maxTargets,
+		timeout:    timeout,
+		pending:    map[string]*renderBatch{},
+	}
+}
+
+// fetch adds the target to the batch for the server and interval and waits
+// for the series of the target.
+func (b *renderBatcher) fetch(ctx context.Context, g *graphite, target, interval string) ([]Series, error) {
+	key := g.pool.key + "|" + interval + "|" + strconv.Itoa(g.retries)
+	waiter := make(chan batchResult, 1)
+
+	b.mu.Lock()
+	batch, found := b.pending[key]
+	if !found {
+		batch = &renderBatch{g: g, interval: interval}
+		b.pending[key] = batch
+		time.AfterFunc(b.window, func() { b.flush(key, batch) })
+	}
+	batch.targets = append(batch.targets, target)
+	batch.waiters = append(batch.waiters, waiter)
+	if len(batch.targets) >= b.maxTargets {
+		delete(b.pending, key)
+		go b.send(batch)
+	}
+	b.mu.Unlock()
+
+	select {
+	case res := <-waiter:
+		for _, request := range res.requests {
+			traceFrom(ctx).addRequest(request)
+		}
+		if res.endpoint != "" {
+			traceFrom(ctx).setEndpoint(res.endpoint)
+		}
+		return res.series, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flush sends the batch when it was not already sent because it was full.
+func (b *renderBatcher) flush(key string, batch *renderBatch) {
+	b.mu.Lock()
+	if b.pending[key] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// send requests all targets of the batch at once. Every target is wrapped in
+// aliasSub to prefix its series with the index of the target, so that the
+// series can be handed back to the check which requested them. The targets are
+// posted as a form, as a long list of them does not fit into an url.
+func (b *renderBatcher) send(batch *renderBatch) {
+	targets := make([]string, len(batch.targets))
+	for i, target := range batch.targets {
+		targets[i] = fmt.Sprintf("aliasSub(%s,'^','%s%d.')", target, batchPrefix, i)
+	}
+
+	series, request, endpoint, err := b.render(batch.g, batch.interval, targets...)
+	var answered *answeredError
+	if errors.As(err, &answered) && len(batch.targets) > 1 {
+		// A single invalid target fails the whole request, so the targets are
+		// requested one by one to only fail the checks they belong to. When
+		// the server is unavailable, the error is handed to all checks
+		// instead of sending even more requests to it.
+		for i, target := range batch.targets {
+			go func(waiter chan batchResult, target string) {
+				series, single, endpoint, err := b.render(batch.g, batch.interval, target)
+				waiter <- batchResult{series: series, err: err, requests: []traceRequest{request, single}, endpoint: endpoint}
+			}(batch.waiters[i], target)
+		}
+		return
+	}
+	if err != nil {
+		for _, waiter := range batch.waiters {
+			waiter <- batchResult{err: err, requests: []traceRequest{request}}
+		}
+		return
+	}
+
+	split := make([][]Series, len(batch.targets))
+	for _, s := range series {
+		i, name, ok := splitBatchName(s.Name)
+		if !ok || i >= len(split) {
+			continue
+		}
+		s.Name = name
+		if tagName, found := s.Tags["name"]; found {
+			if _, tagName, ok := splitBatchName(tagName); ok {
+				s.Tags["name"] = tagName
+			}
+		}
+		split[i] = append(split[i], s)
+	}
+	for i, waiter := range batch.waiters {
+		waiter <- batchResult{series: split[i], requests: []traceRequest{request}, endpoint: endpoint}
+	}
+}
+
+// render posts the targets to the render api and returns the series with the
+// request and the server which answered.
+func (b *renderBatcher) render(g *graphite, interval string, targets ...string) ([]Series, traceRequest, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	ctx, trace := withTrace(ctx)
+	raw, err := g.post(ctx, "/render", renderForm(interval, targets...))
+	var request traceRequest
+	if len(trace.Requests) > 0 {
+		request = trace.Requests[len(trace.Requests)-1]
+	}
+	if err != nil {
+		return nil, request, trace.Endpoint, err
+	}
+	series, err := parseRender(raw)
+	if err != nil {
+		// The server answered, but possibly choked on one of the targets.
+		return nil, request, trace.Endpoint, &answeredError{err}
+	}
+	return series, request, trace.Endpoint, nil
+}
+
+// splitBatchName returns the index of the target and the original name of a
+// series prefixed by send.
+func splitBatchName(name string) (int, string, bool) {
+	if !strings.HasPrefix(name, batchPrefix) {
+		return 0, name, false
+	}
+	idx, rest, found := strings.Cut(strings.TrimPrefix(name, batchPrefix), ".")
+	if !found {
+		return 0, name, false
+	}
+	i, err := strconv.Atoi(idx)
+	if err != nil {
+		return 0, name, false
+	}
+	return i, rest, true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+func TestSplitBatchName(t *testing.T) {
+	tests := []struct {
+		name  string
+		index int
+		rest  string
+		ok    bool
+	}{
+		{"_cgbatch0.a.b", 0, "a.b", true},
+		{"_cgbatch12.sumSeries(a.*.c)", 12, "sumSeries(a.*.c)", true},
+		{"a.b", 0, "a.b", false},
+		{"_cgbatch.a.b", 0, "_cgbatch.a.b", false},
+		{"_cgbatchx.a.b", 0, "_cgbatchx.a.b", false},
+		{"_cgbatch3", 0, "_cgbatch3", false},
+	}
+	for _, test := range tests {
+		index, rest, ok := splitBatchName(test.name)
+		if index != test.index || rest != test.rest || ok != test.ok {
+			t.Errorf("%s: got %d, %s, %t, expected %d, %s, %t", test.name, index, rest, ok, test.index, test.rest, test.ok)
+		}
+	}
+}
+
+// sendBatch sends the targets as one batch and returns the series and errors
+// in the order of the targets.
+func sendBatch(t *testing.T, g *fakeGraphite, targets ...string) ([][]Series, []error) {
+	t.Helper()
+	r := &runner{client: g.Client(), pools: newPoolRegistry(nil, nil)}
+	pool, err := r.pools.pool(g.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newRenderBatcher(50*time.Millisecond, 100, 5*time.Second)
+	batch := &renderBatch{g: newGraphite(r, pool, 0), interval: "5min", targets: targets}
+	for range targets {
+		batch.waiters = append(batch.waiters, make(chan batchResult, 1))
+	}
+	b.send(batch)
+
+	series := make([][]Series, len(targets))
+	errs := make([]error, len(targets))
+	for i, waiter := range batch.waiters {
+		res := <-waiter
+		series[i], errs[i] = res.series, res.err
+	}
+	return series, errs
+}
+
+func TestBatchSend(t *testing.T) {
+	g := newFakeGraphite(t, fakeResponse{body: renderBody(
+		fakeSeries{target: "_cgbatch1.c.d", values: []interface{}{2.0}},
+		fakeSeries{target: "_cgbatch0.a.b", tags: map[string]string{"name": "_cgbatch0.a.b"}, values: []interface{}{1.0}},
+	)})
+	series, errs := sendBatch(t, g, "a.b", "c.d")
+
+	for i, name := range []string{"a.b", "c.d"} {
+		if errs[i] != nil {
+			t.Fatalf("%s: %s", name, errs[i])
+		}
+		if len(series[i]) != 1 || series[i][0].Name != name {
+			t.Fatalf("%s: got series %v", name, series[i])
+		}
+	}
+	if name := series[0][0].Tags["name"]; name != "a.b" {
+		t.Errorf("got name tag %s, expected a.b", name)
+	}
+	received, methods := g.received(), g.receivedMethods()
+	if len(received) != 1 || methods[0] != http.MethodPost {
+		t.Fatalf("expected one post request, got %v %v", methods, received)
+	}
+	if targets := received[0].Query()["target"]; len(targets) != 2 {
+		t.Errorf("got targets %v", targets)
+	}
+}
+
+func TestBatchSendRetriesTargets(t *testing.T) {
+	g := newFakeGraphite(t,
+		fakeResponse{status: http.StatusBadRequest},
+		ok("a.b", 1.0),
+	)
+	series, errs := sendBatch(t, g, "a.b", "a.b")
+
+	for i := range series {
+		if errs[i] != nil || len(series[i]) != 1 {
+			t.Errorf("target %d: got series %v and error %v", i, series[i], errs[i])
+		}
+	}
+	if received := len(g.received()); received != 3 {
+		t.Errorf("got %d requests, expected the batch and one for every target", received)
+	}
+}
+
+func TestBatchSendUnparsable(t *testing.T) {
+	g := newFakeGraphite(t,
+		fakeResponse{body: "<html>"},
+		ok("a.b", 1.0),
+	)
+	_, errs := sendBatch(t, g, "a.b", "a.b")
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("target %d: %s", i, err)
+		}
+	}
+	if received := len(g.received()); received != 3 {
+		t.Errorf("got %d requests, expected the batch and one for every target", received)
+	}
+}
+
+func TestBatchSendUnavailable(t *testing.T) {
+	g := newFakeGraphite(t, fakeResponse{status: http.StatusServiceUnavailable})
+	_, errs := sendBatch(t, g, "a.b", "c.d", "e.f")
+
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("target %d: expected the error of the batch", i)
+		}
+	}
+	if received := len(g.received()); received != 1 {
+		t.Errorf("got %d requests, expected only the batch", received)
+	}
+}
+
+func TestHealthBypassesBatcher(t *testing.T) {
+	g := newFakeGraphite(t, ok("canary", 1.0))
+	r := &runner{client: g.Client(), batcher: newRenderBatcher(time.Minute, 100, 5*time.Second)}
+	command := []string{"check_graphite", "-addr", g.URL, "-mode", "health", "-key", "canary", "-interval", "5min"}
+	result := r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	if result.ExitCode != 0 {
+		t.Errorf("got exit code %d with message %q", result.ExitCode, result.Message)
+	}
+	received, methods := g.received(), g.receivedMethods()
+	if len(received) != 1 || methods[0] != http.MethodGet || received[0].Query().Get("target") != "canary" {
+		t.Errorf("expected the canary to be requested directly, got %v %v", methods, received)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// circuitBreaker stops requests to a backend after too many consecutive
+	// failures. While the circuit is open, checks fail fast instead of
+	// waiting for the backend and a single request is let through every probe
+	// interval to find out if the backend is back.
+	circuitBreaker struct {
+		threshold     int // threshold is the number of failures to open, 0 disables the breaker
+		probeInterval time.Duration
+
+		mu        sync.Mutex
+		failures  int
+		openSince time.Time // openSince is zero while the circuit is closed
+		probing   bool
+		nextProbe time.Time
+	}
+
+	// circuitOpenError is returned for requests rejected by an open circuit.
+	circuitOpenError struct {
+		since time.Time
+	}
+)
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("backend unavailable, circuit open since %s", e.since.Format(time.RFC3339))
+}
+
+func newCircuitBreaker(threshold int, probeInterval time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, probeInterval: probeInterval}
+}
+
+// allow returns an error when the request must not be sent. When the circuit
+// is open and the probe interval passed, one request is allowed as probe.
+func (b *circuitBreaker) allow() error {
+	if b == nil || b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openSince.IsZero() {
+		return nil
+	}
+	if !b.probing && !time.Now().Before(b.nextProbe) {
+		b.probing = true
+		return nil
+	}
+	stats.circuit.Inc("")
+	return &circuitOpenError{since: b.openSince}
+}
+
+// record updates the state with the outcome of an allowed request. A request
+// succeeded when the backend answered, even with an error. Requests without an
+// outcome must be released instead.
+func (b *circuitBreaker) record(success bool) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if success {
+		b.failures = 0
+		b.openSince = time.Time{}
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.probing {
+		b.probing = false
+		b.nextProbe = now.Add(b.probeInterval)
+		return
+	}
+	if b.openSince.IsZero() && b.failures >= b.threshold {
+		b.openSince = now
+		b.nextProbe = now.Add(b.probeInterval)
+	}
+}
+
+// release ends an allowed request which was never answered nor failed, like
+// one given up by the check. A probe may be sent again right away.
+func (b *circuitBreaker) release() {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// responseCache caches the responses of the backends for a short time and
+	// coalesces concurrent requests for the same url, so that checks querying
+	// the same target with different levels only fetch it once.
+	responseCache struct {
+		ttl       time.Duration
+		mu        sync.Mutex
+		entries   map[string]*cacheEntry
+		lastSweep time.Time
+	}
+
+	cacheEntry struct {
+		done    chan struct{} // done is closed when the response is available
+		raw     []byte
+		err     error
+		expires time.Time
+	}
+)
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:       ttl,
+		entries:   map[string]*cacheEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the cached response for the url on the endpoint or calls fetch
+// to get it. Named servers may share an url, but send different credentials,
+// so responses are only shared between requests to the same endpoint. When a
+// fetch for the url is already running, its result is awaited instead.
+// Errors are handed to all waiting callers but are not cached.
+func (c *responseCache) get(ctx context.Context, endpoint, rawURL string, fetch func() ([]byte, error)) ([]byte, error) {
+	key := endpoint + " " + normalizeURL(rawURL)
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if found {
+		select {
+		case <-entry.done:
+			if entry.err != nil || now.After(entry.expires) {
+				found = false
+			}
+		default:
+		}
+	}
+	if found {
+		c.mu.Unlock()
+		select {
+		case <-entry.done:
+			stats.cache.Inc("hit")
+		default:
+			stats.cache.Inc("coalesced")
+		}
+		traceFrom(ctx).addRequest(traceRequest{URL: rawURL, Cached: true})
+		select {
+		case <-entry.done:
+			return entry.raw, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	entry = &cacheEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.sweep(now)
+	c.mu.Unlock()
+	stats.cache.Inc("miss")
+
+	entry.raw, entry.err = fetch()
+	entry.expires = time.Now().Add(c.ttl)
+	close(entry.done)
+	if entry.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	return entry.raw, entry.err
+}
+
+// sweep removes all expired entries, but at most once per ttl. The lock must be
+// held by the caller.
+func (c *responseCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for key, entry := range c.entries {
+		select {
+		case <-entry.done:
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		default:
+		}
+	}
+}
+
+// normalizeURL returns the url with its query parameters sorted, so that the
+// same query always results in the same key. The absolute start and end of a
+// prometheus query move with every second, so they are replaced by the length
+// of the range and the age of its end in minutes. Within the ttl, responses
+// for the same range are shared like the relative ranges of graphite.
+func normalizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	start, errStart := strconv.ParseInt(query.Get("start"), 10, 64)
+	end, errEnd := strconv.ParseInt(query.Get("end"), 10, 64)
+	if errStart == nil && errEnd == nil {
+		query.Del("start")
+		query.Del("end")
+		query.Set("range", strconv.FormatInt(end-start, 10))
+		query.Set("age", strconv.FormatInt((time.Now().Unix()-end)/60, 10))
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
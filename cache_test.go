@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	// promURL returns a query ending the seconds ago with the range.
+	now := time.Now().Unix()
+	promURL := func(ago, length int64) string {
+		return fmt.Sprintf("http://prom/api/v1/query_range?query=up&start=%d&end=%d&step=15", now-ago-length, now-ago)
+	}
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"http://graphite/render?target=a.b&from=-5min", "http://graphite/render?from=-5min&target=a.b", true},
+		{"http://graphite/render?target=a.b&from=-5min", "http://graphite/render?target=a.b&from=-10min", false},
+		{promURL(600, 300), promURL(607, 300), true},
+		{promURL(600, 300), promURL(600, 600), false},
+		{promURL(600, 300), promURL(600+24*3600, 300), false},
+	}
+	for _, test := range tests {
+		if equal := normalizeURL(test.a) == normalizeURL(test.b); equal != test.equal {
+			t.Errorf("%s and %s: got equal %t, expected %t", test.a, test.b, equal, test.equal)
+		}
+	}
+}
+
+func TestHealthBypassesCache(t *testing.T) {
+	g := newFakeGraphite(t, ok("canary", 1.0))
+	r := &runner{client: g.Client(), cache: newResponseCache(time.Minute)}
+	command := []string{"check_graphite", "-addr", g.URL, "-mode", "health", "-key", "canary", "-interval", "5min"}
+	for i := 0; i < 2; i++ {
+		r.runCheck(monzero.Check{Command: command}, context.Background())
+	}
+	if received := len(g.received()); received != 2 {
+		t.Errorf("got %d requests, expected both health checks to reach the server", received)
+	}
+
+	command = []string{"check_graphite", "-addr", g.URL, "-key", "canary", "-interval", "5min", "-warn", "5", "-error", "10"}
+	for i := 0; i < 2; i++ {
+		r.runCheck(monzero.Check{Command: command}, context.Background())
+	}
+	if received := len(g.received()); received != 3 {
+		t.Errorf("got %d requests, expected the second render check to be cached", received)
+	}
+}
+
+func TestCacheSeparatesServers(t *testing.T) {
+	g := newFakeGraphite(t, ok("a.b", 1.0))
+	servers, err := newServers(map[string]ServerConfig{
+		"org1": {URL: g.URL, Headers: map[string]string{"X-Grafana-Org-Id": "1"}},
+		"org2": {URL: g.URL, Headers: map[string]string{"X-Grafana-Org-Id": "2"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &runner{client: g.Client(), cache: newResponseCache(time.Minute), pools: newPoolRegistry(nil, servers)}
+	for _, server := range []string{"org1", "org2", "org1"} {
+		command := []string{"check_graphite", "-server", server, "-key", "a.b", "-warn", "5", "-error", "10"}
+		r.runCheck(monzero.Check{Command: command}, context.Background())
+	}
+	if received := len(g.received()); received != 2 {
+		t.Errorf("got %d requests, expected one for every server", received)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+type (
+	// CarbonConfig configures writing the check results back to carbon.
+	CarbonConfig struct {
+		// Addr is the host:port of the carbon receiver. Writing is disabled
+		// when empty.
+		Addr string `toml:"addr"`
+		// Protocol is either plaintext or pickle.
+		Protocol string `toml:"protocol"`
+		// Prefix is prepended to all metric names.
+		Prefix string `toml:"prefix"`
+		// BatchSize is the maximum number of metrics sent at once.
+		BatchSize int `toml:"batch_size"`
+		// FlushInterval is the number of seconds after which incomplete batches
+		// are sent.
+		FlushInterval int `toml:"flush_interval"`
+	}
+
+	// carbonWriter sends metrics asynchronously in batches to carbon.
+	carbonWriter struct {
+		cfg     CarbonConfig
+		metrics chan carbonMetric
+		conn    net.Conn
+	}
+
+	carbonMetric struct {
+		path      string
+		value     float64
+		timestamp int64
+	}
+)
+
+// newCarbonWriter returns a writer for the configuration and starts sending
+// metrics in the background.
+func newCarbonWriter(cfg CarbonConfig) (*carbonWriter, error) {
+	switch cfg.Protocol {
+	case "":
+		cfg.Protocol = "plaintext"
+	case "plaintext", "pickle":
+	default:
+		return nil, fmt.Errorf("unknown carbon protocol '%s'", cfg.Protocol)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "check_graphite"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 10
+	}
+	w := &carbonWriter{
+		cfg:     cfg,
+		metrics: make(chan carbonMetric, 10*cfg.BatchSize),
+	}
+	go w.run()
+	return w, nil
+}
+
+// writeResult queues the value, exit code and duration of a check run. The
+// value is skipped when nil.
+func (w *carbonWriter) writeResult(name string, value *float64, exitCode int, duration time.Duration) {
+	now := time.Now().Unix()
+	path := w.cfg.Prefix + "." + name
+	if value != nil {
+		w.add(carbonMetric{path + ".value", *value, now})
+	}
+	w.add(carbonMetric{path + ".state", float64(exitCode), now})
+	w.add(carbonMetric{path + ".duration", duration.Seconds(), now})
+}
+
+// add queues a metric. When the queue is full, because carbon does not keep
+// up, the metric is dropped instead of blocking the check.
+func (w *carbonWriter) add(m carbonMetric) {
+	select {
+	case w.metrics <- m:
+	default:
+		slog.Warn("carbon queue full, dropping metric", "path", m.path)
+	}
+}
+
+func (w *carbonWriter) run() {
+	ticker := time.NewTicker(time.Duration(w.cfg.FlushInterval) * time.Second)
+	defer ticker.Stop()
+	batch := make([]carbonMetric, 0, w.cfg.BatchSize)
+	for {
+		select {
+		case m := <-w.metrics:
+			batch = append(batch, m)
+			if len(batch) < w.cfg.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := w.send(batch); err != nil {
+			slog.Error("could not send metrics to carbon", "metrics", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// send writes the batch to carbon. The connection is reused between batches
+// and opened again after an error.
+func (w *carbonWriter) send(batch []carbonMetric) error {
+	var payload []byte
+	if w.cfg.Protocol == "pickle" {
+		payload = encodePickle(batch)
+	} else {
+		payload = encodePlaintext(batch)
+	}
+
+	if w.conn == nil {
+		conn, err := net.DialTimeout("tcp", w.cfg.Addr, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("could not connect: %s", err)
+		}
+		w.conn = conn
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := w.conn.Write(payload); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return fmt.Errorf("could not write: %s", err)
+	}
+	return nil
+}
+
+// encodePlaintext encodes the metrics in the line based plaintext protocol.
+func encodePlaintext(batch []carbonMetric) []byte {
+	buf := &bytes.Buffer{}
+	for _, m := range batch {
+		fmt.Fprintf(buf, "%s %g %d\n", m.path, m.value, m.timestamp)
+	}
+	return buf.Bytes()
+}
+
+// encodePickle encodes the metrics as a list of (path, (timestamp, value))
+// tuples in the pickle protocol 2, prefixed by the length of the payload.
+func encodePickle(batch []carbonMetric) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0x80, 2}) // PROTO 2
+	buf.WriteByte(']')         // EMPTY_LIST
+	buf.WriteByte('(')         // MARK
+	for _, m := range batch {
+		buf.WriteByte('X') // BINUNICODE
+		binary.Write(buf, binary.LittleEndian, uint32(len(m.path)))
+		buf.WriteString(m.path)
+		buf.WriteByte('G') // BINFLOAT
+		binary.Write(buf, binary.BigEndian, math.Float64bits(float64(m.timestamp)))
+		buf.WriteByte('G') // BINFLOAT
+		binary.Write(buf, binary.BigEndian, math.Float64bits(m.value))
+		buf.WriteByte(0x86) // TUPLE2 (timestamp, value)
+		buf.WriteByte(0x86) // TUPLE2 (path, datapoint)
+	}
+	buf.WriteByte('e') // APPENDS
+	buf.WriteByte('.') // STOP
+
+	payload := make([]byte, 4, 4+buf.Len())
+	binary.BigEndian.PutUint32(payload, uint32(buf.Len()))
+	return append(payload, buf.Bytes()...)
+}
+
+// metricName converts a target into a name usable as part of a metric path.
+func metricName(target string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, target)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestEncodePickle(t *testing.T) {
+	batch := []carbonMetric{{"a.b", 1.5, 1000}, {"c", -2, 1001}}
+	// The golden bytes unpickle to [('a.b', (1000.0, 1.5)), ('c', (1001.0, -2.0))]
+	// with python.
+	expected := []byte{
+		0, 0, 0, 0x3c, // length of the payload
+		0x80, 2, // PROTO 2
+		']', '(', // EMPTY_LIST, MARK
+		'X', 3, 0, 0, 0, 'a', '.', 'b', // BINUNICODE a.b
+		'G', 0x40, 0x8f, 0x40, 0, 0, 0, 0, 0, // BINFLOAT 1000
+		'G', 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, // BINFLOAT 1.5
+		0x86, 0x86, // TUPLE2, TUPLE2
+		'X', 1, 0, 0, 0, 'c', // BINUNICODE c
+		'G', 0x40, 0x8f, 0x48, 0, 0, 0, 0, 0, // BINFLOAT 1001
+		'G', 0xc0, 0, 0, 0, 0, 0, 0, 0, // BINFLOAT -2
+		0x86, 0x86, // TUPLE2, TUPLE2
+		'e', '.', // APPENDS, STOP
+	}
+	if got := encodePickle(batch); !bytes.Equal(got, expected) {
+		t.Errorf("got\n%x\nexpected\n%x", got, expected)
+	}
+}
+
+func TestSendPlaintext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	lines := make(chan string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	w := &carbonWriter{cfg: CarbonConfig{Addr: listener.Addr().String(), Protocol: "plaintext"}}
+	defer func() {
+		if w.conn != nil {
+			w.conn.Close()
+		}
+	}()
+	if err := w.send([]carbonMetric{{"check_graphite.a_b.value", 1.5, 1000}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.send([]carbonMetric{{"check_graphite.a_b.state", 2, 1001}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"check_graphite.a_b.value 1.5 1000", "check_graphite.a_b.state 2 1001"} {
+		if line := <-lines; line != expected {
+			t.Errorf("got line %q, expected %q", line, expected)
+		}
+	}
+}
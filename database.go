@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// minBackoff and maxBackoff limit the time a worker waits after a
+	// database error before trying again.
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// DBConfig configures the connection pool to the database.
+type DBConfig struct {
+	MaxOpen int `toml:"max_open"`
+	MaxIdle int `toml:"max_idle"`
+	// MaxLifetime and MaxIdleTime are the number of seconds a connection is
+	// used or kept idle before it is closed.
+	MaxLifetime int `toml:"max_lifetime"`
+	MaxIdleTime int `toml:"max_idle_time"`
+}
+
+// openDB opens the database, configures the pool and checks that the
+// database can be reached.
+func openDB(dsn string, cfg DBConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database connection: %s", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpen)
+	db.SetMaxIdleConns(cfg.MaxIdle)
+	db.SetConnMaxLifetime(time.Duration(cfg.MaxLifetime) * time.Second)
+	db.SetConnMaxIdleTime(time.Duration(cfg.MaxIdleTime) * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not connect to database: %s", err)
+	}
+	return db, nil
+}
+
+// dbMonitor tracks if the database is reachable by the workers and logs when
+// the state changes, instead of logging every failed attempt.
+type dbMonitor struct {
+	mu        sync.Mutex
+	downSince time.Time // downSince is zero while the database is reachable
+}
+
+// failed records an error of a worker and returns the time to wait before the
+// next attempt, doubling the last backoff.
+func (m *dbMonitor) failed(worker int, err error, backoff time.Duration) time.Duration {
+	m.mu.Lock()
+	if m.downSince.IsZero() {
+		m.downSince = time.Now()
+		slog.Error("database not reachable", "worker", worker, "error", err)
+	} else {
+		slog.Debug("database still not reachable", "worker", worker, "error", err, "down since", m.downSince)
+	}
+	m.mu.Unlock()
+
+	backoff *= 2
+	if backoff < minBackoff {
+		backoff = minBackoff
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// succeeded records a successful access of the database.
+func (m *dbMonitor) succeeded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.downSince.IsZero() {
+		return
+	}
+	slog.Info("database reachable again", "down for", time.Since(m.downSince).Round(time.Second))
+	m.downSince = time.Time{}
+}
+
+// up returns true while the database is reachable.
+func (m *dbMonitor) up() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.downSince.IsZero()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+	"github.com/lib/pq"
+)
+
+// dryRunCheck is a due check with its stored result and the result of the
+// dry run.
+type dryRunCheck struct {
+	id      int64
+	command []string
+	states  []int64
+	msg     string
+	result  monzero.CheckResult
+}
+
+// dryRun runs all due checks of the checker once without writing anything to
+// the database and writes a report of the checks, whose state differs from the
+// stored one, to w.
+func dryRun(db *sql.DB, checkerID int, r *runner, jobs int, w io.Writer) error {
+	rows, err := db.Query(`select check_id, cmdLine, states, coalesce(msg, '')
+		from active_checks
+		where next_time < now()
+			and enabled
+			and checker_id = $1
+		order by next_time`, checkerID)
+	if err != nil {
+		return fmt.Errorf("could not get due checks: %s", err)
+	}
+	checks := []*dryRunCheck{}
+	for rows.Next() {
+		check := &dryRunCheck{}
+		if err := rows.Scan(&check.id, pq.Array(&check.command), pq.Array(&check.states), &check.msg); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not read check: %s", err)
+		}
+		checks = append(checks, check)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not read checks: %s", err)
+	}
+
+	queue := make(chan *dryRunCheck)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range queue {
+				check.result = r.runTimed(context.Background(), monzero.Check{Command: check.command}, 30*time.Second)
+			}
+		}()
+	}
+	for _, check := range checks {
+		queue <- check
+	}
+	close(queue)
+	wg.Wait()
+
+	changed := 0
+	for _, check := range checks {
+		stored := "PENDING"
+		if len(check.states) > 0 {
+			stored = stateName(int(check.states[0]))
+		}
+		current := stateName(check.result.ExitCode)
+		if stored == current {
+			continue
+		}
+		changed++
+		fmt.Fprintf(w, "check %d: %s -> %s\n", check.id, stored, current)
+		fmt.Fprintf(w, "\tstored: %s\n", strings.TrimSpace(check.msg))
+		fmt.Fprintf(w, "\tnow: %s\n", strings.TrimSpace(check.result.Message))
+	}
+	fmt.Fprintf(w, "%d due checks run, %d changed state\n", len(checks), changed)
+	return nil
+}
+
+// runTimed runs the check with the timeout and reports it as critical when
+// it took too long, like the checker of the daemon does.
+func (r *runner) runTimed(ctx context.Context, check monzero.Check, timeout time.Duration) monzero.CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result := r.runCheck(check, ctx)
+	if ctx.Err() == context.DeadlineExceeded {
+		result.Message = fmt.Sprintf("check took longer than %s", timeout)
+		result.ExitCode = 2
+	}
+	return result
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	g := newFakeGraphite(t, ok("a.b", 11.0))
+	command := "{check_graphite,-addr," + g.URL + ",-key,a.b,-warn,5,-error,10}"
+	fake := &fakeDB{queries: []fakeQuery{{
+		match:   "from active_checks",
+		columns: []string{"check_id", "cmdline", "states", "msg"},
+		rows: [][]driver.Value{
+			{int64(1), command, "{2,0}", "current value: 12.000000\n"},
+			{int64(2), command, "{0}", "current value: 1.000000\n"},
+		},
+	}}}
+
+	report := &strings.Builder{}
+	r := &runner{client: g.Client(), pools: newPoolRegistry(nil, nil)}
+	if err := dryRun(fake.open(t), 1, r, 2, report); err != nil {
+		t.Fatalf("could not run checks: %s", err)
+	}
+	expected := "check 2: OK -> CRITICAL\n" +
+		"\tstored: current value: 1.000000\n" +
+		"\tnow: current value: 11.000000\n" +
+		"2 due checks run, 1 changed state\n"
+	if report.String() != expected {
+		t.Errorf("got report %q, expected %q", report.String(), expected)
+	}
+	if execs := fake.executed(); len(execs) != 0 {
+		t.Errorf("dry run executed %d statements", len(execs))
+	}
+}
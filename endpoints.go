@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ejectAfter is the number of consecutive failures after which an
+	// endpoint is ejected.
+	ejectAfter = 3
+	// ejectFor is the duration an ejected endpoint is only used when all
+	// other endpoints failed too.
+	ejectFor = 30 * time.Second
+)
+
+type (
+	// ClusterConfig defines a named set of replicated servers, which can be
+	// used in place of an address.
+	ClusterConfig struct {
+		Addrs      []string `toml:"addrs"`
+		RoundRobin bool     `toml:"round_robin"`
+	}
+
+	// endpoint is a single server of a pool with its passive health state.
+	endpoint struct {
+		name   string // name is used in messages
+		url    *url.URL
+		client *http.Client // client is used instead of the default when set
+		header http.Header  // header is added to every request
+		// limiter is shared by all endpoints of the same server
+		limiter *requestLimiter
+
+		mu           sync.Mutex
+		failures     int
+		ejectedUntil time.Time
+	}
+
+	// endpointPool contains the replicated servers a check can be sent to.
+	// Requests fail over to the next endpoint on transport errors and
+	// internal errors of the server.
+	endpointPool struct {
+		key        string
+		endpoints  []*endpoint
+		roundRobin bool
+		next       atomic.Uint64
+		breaker    *circuitBreaker
+	}
+
+	// poolRegistry shares the pools, and with them the health state of the
+	// endpoints, between all checks.
+	poolRegistry struct {
+		clusters map[string]ClusterConfig
+		servers  map[string]*endpoint // servers are copied into the pools
+		mu       sync.Mutex
+		pools    map[string]*endpointPool
+
+		// breakerThreshold and probeInterval configure the circuit breaker of
+		// every pool. The breaker is disabled when the threshold is 0.
+		breakerThreshold int
+		probeInterval    time.Duration
+
+		// maxInFlight and rateLimit are the limits of servers given by url.
+		maxInFlight int
+		rateLimit   float64
+		limiters    map[string]*requestLimiter
+	}
+
+	// unavailableError marks errors after which the request should be sent
+	// to another endpoint.
+	unavailableError struct {
+		err error
+	}
+
+	// answeredError marks errors the backend answered with, which show that
+	// it is reachable.
+	answeredError struct {
+		err error
+	}
+)
+
+func (e *unavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e *answeredError) Error() string {
+	return e.err.Error()
+}
+
+func (e *answeredError) Unwrap() error {
+	return e.err
+}
+
+func newPoolRegistry(clusters map[string]ClusterConfig, servers map[string]*endpoint) *poolRegistry {
+	return &poolRegistry{
+		clusters: clusters,
+		servers:  servers,
+		pools:    map[string]*endpointPool{},
+		limiters: map[string]*requestLimiter{},
+	}
+}
+
+// isNamed returns true when name is a server or cluster from the config.
+func (reg *poolRegistry) isNamed(name string) bool {
+	_, isServer := reg.servers[name]
+	_, isCluster := reg.clusters[name]
+	return isServer || isCluster
+}
+
+// pool returns the pool for addr, which is either the name of a cluster or a
+// comma separated list of server names and urls.
+func (reg *poolRegistry) pool(addr string, roundRobin bool) (*endpointPool, error) {
+	key := fmt.Sprintf("%s|%t", addr, roundRobin)
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if pool, found := reg.pools[key]; found {
+		return pool, nil
+	}
+
+	addrs := strings.Split(addr, ",")
+	if cluster, found := reg.clusters[addr]; found {
+		addrs = cluster.Addrs
+		roundRobin = roundRobin || cluster.RoundRobin
+	}
+	pool := &endpointPool{
+		key:        key,
+		roundRobin: roundRobin,
+		breaker:    newCircuitBreaker(reg.breakerThreshold, reg.probeInterval),
+	}
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if server, found := reg.servers[addr]; found {
+			pool.endpoints = append(pool.endpoints, &endpoint{
+				name:    server.name,
+				url:     server.url,
+				client:  server.client,
+				header:  server.header,
+				limiter: server.limiter,
+			})
+			continue
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse addr '%s': %s", addr, err)
+		}
+		limiter, found := reg.limiters[u.Host]
+		if !found {
+			limiter = newRequestLimiter(reg.maxInFlight, reg.rateLimit)
+			reg.limiters[u.Host] = limiter
+		}
+		pool.endpoints = append(pool.endpoints, &endpoint{name: u.Redacted(), url: u, limiter: limiter})
+	}
+	if len(pool.endpoints) == 0 {
+		return nil, fmt.Errorf("no address given to check")
+	}
+	reg.pools[key] = pool
+	return pool, nil
+}
+
+// order returns the endpoints in the order they should be tried. Ejected
+// endpoints are moved to the end.
+func (p *endpointPool) order() []*endpoint {
+	start := 0
+	if p.roundRobin {
+		start = int(p.next.Add(1) % uint64(len(p.endpoints)))
+	}
+	now := time.Now()
+	healthy := make([]*endpoint, 0, len(p.endpoints))
+	ejected := []*endpoint{}
+	for i := range p.endpoints {
+		e := p.endpoints[(start+i)%len(p.endpoints)]
+		if e.isEjected(now) {
+			ejected = append(ejected, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+	return append(healthy, ejected...)
+}
+
+// do calls fn with each endpoint until one answers. When the pool has more
+// than one endpoint, the answering one is recorded in the trace.
+// When all endpoints failed too often, the circuit breaker rejects the request
+// without trying any endpoint.
+func (p *endpointPool) do(ctx context.Context, fn func(e *endpoint) ([]byte, error)) ([]byte, error) {
+	if err := p.breaker.allow(); err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, e := range p.order() {
+		raw, err := fn(e)
+		if err == nil {
+			e.succeeded()
+			p.breaker.record(true)
+			if len(p.endpoints) > 1 {
+				traceFrom(ctx).setEndpoint(e.name)
+			}
+			return raw, nil
+		}
+		var (
+			unavailable *unavailableError
+			answered    *answeredError
+		)
+		switch {
+		case ctx.Err() != nil:
+			// The check gave up waiting, which says nothing about the backend.
+			p.breaker.release()
+			return nil, err
+		case errors.As(err, &unavailable):
+			e.failed()
+			lastErr = err
+		case errors.As(err, &answered):
+			p.breaker.record(true)
+			return nil, err
+		default:
+			// The request was never sent, like when it could not be built.
+			p.breaker.release()
+			return nil, err
+		}
+	}
+	p.breaker.record(false)
+	return nil, lastErr
+}
+
+func (e *endpoint) isEjected(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return now.Before(e.ejectedUntil)
+}
+
+func (e *endpoint) succeeded() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures = 0
+	e.ejectedUntil = time.Time{}
+}
+
+// failed counts a failure and ejects the endpoint after too many consecutive
+// failures.
+func (e *endpoint) failed() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures++
+	if e.failures >= ejectAfter {
+		e.ejectedUntil = time.Now().Add(ejectFor)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPoolBreakerOutcomes(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		cancel bool
+		open   bool
+	}{
+		{name: "answered with an error", err: &answeredError{fmt.Errorf("status code 404")}},
+		{name: "request not built", err: fmt.Errorf("could not create request")},
+		{name: "given up by the check", err: &unavailableError{fmt.Errorf("could not get result")}, cancel: true},
+		{name: "unavailable", err: &unavailableError{fmt.Errorf("could not get result")}, open: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reg := newPoolRegistry(nil, nil)
+			reg.breakerThreshold = 1
+			reg.probeInterval = time.Minute
+			pool, err := reg.pool("http://localhost", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				cancel()
+			}
+			pool.do(ctx, func(e *endpoint) ([]byte, error) { return nil, test.err })
+
+			var open *circuitOpenError
+			err = pool.breaker.allow()
+			if errors.As(err, &open) != test.open {
+				t.Errorf("got %v from the breaker, expected open %t", err, test.open)
+			}
+		})
+	}
+}
+
+func TestFetchHoldsSlotUntilBodyRead(t *testing.T) {
+	proceed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-proceed
+		fmt.Fprint(w, "[]")
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &endpoint{name: server.URL, url: u, limiter: newRequestLimiter(1, 0)}
+	r := &runner{client: server.Client()}
+
+	done := make(chan error)
+	go func() {
+		_, err := r.fetch(context.Background(), e, "graphite", server.URL+"/render", "", 0)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if used := len(e.limiter.slots); used != 1 {
+		t.Errorf("got %d slots in use while the body is read, expected 1", used)
+	}
+	close(proceed)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if used := len(e.limiter.slots); used != 0 {
+		t.Errorf("got %d slots in use after the request, expected 0", used)
+	}
+}
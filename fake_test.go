@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// fakeResponse is a scripted answer of the fake graphite server.
+	fakeResponse struct {
+		status int // status defaults to 200
+		body   string
+		delay  time.Duration
+	}
+
+	// fakeGraphite is a render api answering with scripted responses. The
+	// responses are served in order and the last one is repeated.
+	fakeGraphite struct {
+		*httptest.Server
+
+		mu        sync.Mutex
+		responses []fakeResponse
+		requests  []url.URL
+		methods   []string
+	}
+)
+
+// newFakeGraphite starts a fake graphite server, which is closed at the end of
+// the test.
+func newFakeGraphite(t *testing.T, responses ...fakeResponse) *fakeGraphite {
+	t.Helper()
+	if len(responses) == 0 {
+		t.Fatal("fake graphite needs at least one response")
+	}
+	g := &fakeGraphite{responses: responses}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *fakeGraphite) serve(w http.ResponseWriter, r *http.Request) {
+	// The form of posted requests is recorded as the query of the url.
+	r.ParseForm()
+	u := *r.URL
+	u.RawQuery = r.Form.Encode()
+
+	g.mu.Lock()
+	g.requests = append(g.requests, u)
+	g.methods = append(g.methods, r.Method)
+	res := g.responses[0]
+	if len(g.responses) > 1 {
+		g.responses = g.responses[1:]
+	}
+	g.mu.Unlock()
+
+	if res.delay > 0 {
+		select {
+		case <-time.After(res.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+	w.WriteHeader(res.status)
+	fmt.Fprint(w, res.body)
+}
+
+// received returns the urls of all requests received.
+func (g *fakeGraphite) received() []url.URL {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]url.URL{}, g.requests...)
+}
+
+// receivedMethods returns the methods of all requests received.
+func (g *fakeGraphite) receivedMethods() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string{}, g.methods...)
+}
+
+// fakeSeries is a series in the response of the fake render api. The values
+// are placed 60 seconds apart and nil is returned as null.
+type fakeSeries struct {
+	target string
+	tags   map[string]string
+	values []interface{}
+}
+
+// renderBody returns the json body of a render response with the series.
+func renderBody(series ...fakeSeries) string {
+	result := make([]map[string]interface{}, len(series))
+	for i, s := range series {
+		points := make([][]interface{}, len(s.values))
+		for j, value := range s.values {
+			points[j] = []interface{}{value, 1000 + 60*j}
+		}
+		tags := s.tags
+		if tags == nil {
+			tags = map[string]string{"name": s.target}
+		}
+		result[i] = map[string]interface{}{
+			"target":     s.target,
+			"tags":       tags,
+			"datapoints": points,
+		}
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		panic(err)
+	}
+	return string(raw)
+}
+
+// ok returns a successful render response with a single series.
+func ok(target string, values ...interface{}) fakeResponse {
+	return fakeResponse{body: renderBody(fakeSeries{target: target, values: values})}
+}
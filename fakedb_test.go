@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type (
+	// fakeDB is a database answering queries with scripted rows and recording
+	// all statements executed, so the database code can be tested without
+	// postgres.
+	fakeDB struct {
+		mu sync.Mutex
+		// queries are answered by the first one matching and are used only
+		// once. Queries without a match return no rows.
+		queries []fakeQuery
+		// failBegin is the number of transactions failing to start.
+		failBegin int
+		execs     []fakeExec
+		commits   int
+	}
+
+	fakeQuery struct {
+		match   string // match is a part of the query to answer
+		columns []string
+		rows    [][]driver.Value
+	}
+
+	fakeExec struct {
+		query string
+		args  []driver.Value
+	}
+
+	fakeDriver struct{ db *fakeDB }
+	fakeConn   struct{ db *fakeDB }
+	fakeTx     struct{ db *fakeDB }
+
+	fakeStmt struct {
+		db    *fakeDB
+		query string
+	}
+
+	fakeRows struct {
+		columns []string
+		rows    [][]driver.Value
+	}
+)
+
+// open returns a connection pool to the fake database, which is closed at the
+// end of the test.
+func (db *fakeDB) open(t *testing.T) *sql.DB {
+	conn := sql.OpenDB(db)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// executed returns all statements executed so far.
+func (db *fakeDB) executed() []fakeExec {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]fakeExec{}, db.execs...)
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return &fakeDriver{db: db} }
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{db: d.db}, nil }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if c.db.failBegin > 0 {
+		c.db.failBegin--
+		return nil, fmt.Errorf("connection refused")
+	}
+	return &fakeTx{db: c.db}, nil
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.commits++
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error { return nil }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.execs = append(s.db.execs, fakeExec{query: s.query, args: args})
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for i, q := range s.db.queries {
+		if !strings.Contains(s.query, q.match) {
+			continue
+		}
+		s.db.queries = append(s.db.queries[:i], s.db.queries[i+1:]...)
+		return &fakeRows{columns: q.columns, rows: q.rows}, nil
+	}
+	return &fakeRows{}, nil
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+// maxFindNames is the maximum number of metric names listed in the message of
+// a find check.
+const maxFindNames = 25
+
+type (
+	// Finder is implemented by backends which can list the metrics matching a
+	// pattern without fetching their data.
+	Finder interface {
+		// Find returns the names of all leaf nodes matching the pattern.
+		Find(ctx context.Context, pattern string) ([]string, error)
+		// FindTagged returns the names of all series matching the tag
+		// expressions, including their tags.
+		FindTagged(ctx context.Context, exprs []string) ([]string, error)
+	}
+
+	// findResult is the json response of the graphite find api in the
+	// treejson format.
+	findResult []struct {
+		ID   string
+		Text string
+		Leaf int
+	}
+)
+
+func (g *graphite) Find(ctx context.Context, pattern string) ([]string, error) {
+	raw, err := g.get(ctx, func(u url.URL) string {
+		u.Path = u.Path + "/metrics/find"
+		query := u.Query()
+		query.Set("format", "treejson")
+		query.Set("query", pattern)
+		u.RawQuery = query.Encode()
+		return u.String()
+	})
+	if err != nil {
+		return nil, err
+	}
+	payload := findResult{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("could not parse json content: %s\n%s", err, raw)
+	}
+
+	names := []string{}
+	for _, node := range payload {
+		if node.Leaf == 1 {
+			names = append(names, node.ID)
+		}
+	}
+	return names, nil
+}
+
+func (g *graphite) FindTagged(ctx context.Context, exprs []string) ([]string, error) {
+	for _, expr := range exprs {
+		if _, _, _, err := parseTagExpr(expr); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := g.get(ctx, func(u url.URL) string {
+		u.Path = u.Path + "/tags/findSeries"
+		query := u.Query()
+		for _, expr := range exprs {
+			query.Add("expr", expr)
+		}
+		u.RawQuery = query.Encode()
+		return u.String()
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	if err := json.Unmarshal(raw, &names); err != nil {
+		return nil, fmt.Errorf("could not parse json content: %s\n%s", err, raw)
+	}
+	return names, nil
+}
+
+// checkFind counts the metrics matching the key or tags and checks the count
+// against the warning and error levels.
+func checkFind(ctx context.Context, backend Backend, opts *checkOptions) monzero.CheckResult {
+	result := monzero.CheckResult{ExitCode: 3}
+
+	finder, ok := backend.(Finder)
+	if !ok {
+		result.Message = fmt.Sprintf("backend %s does not support the find mode", opts.backend)
+		return result
+	}
+
+	var (
+		names []string
+		err   error
+	)
+	if len(opts.tags) > 0 {
+		names, err = finder.FindTagged(ctx, opts.tags)
+	} else {
+		names, err = finder.Find(ctx, opts.key)
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	count := float64(len(names))
+	traceFrom(ctx).Value = &count
+	result.ExitCode, _ = evaluate(
+		[]Series{{Name: "count", Points: []Point{{Value: &count}}}},
+		opts.levelWarn,
+		opts.levelErr,
+	)
+
+	msg := strings.Builder{}
+	fmt.Fprintf(&msg, opts.message+"\n", count)
+	for i, name := range names {
+		if i == maxFindNames {
+			fmt.Fprintf(&msg, "... and %d more\n", len(names)-maxFindNames)
+			break
+		}
+		msg.WriteString(name + "\n")
+	}
+	result.Message = msg.String()
+	return result
+}
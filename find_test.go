@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+func TestFindTagged(t *testing.T) {
+	g := newFakeGraphite(t, fakeResponse{body: `["cpu.load;dc=fra;host=a","cpu.load;dc=fra;host=b","cpu.load;dc=ber;host=c"]`})
+	r := &runner{client: g.Client()}
+	command := []string{"check_graphite", "-addr", g.URL, "-mode", "find",
+		"-tags", "name=cpu.load", "-tags", "dc=~.*", "-warn", "2", "-error", "1"}
+	result := r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	if result.ExitCode != 0 {
+		t.Errorf("got exit code %d with message %q", result.ExitCode, result.Message)
+	}
+	if !strings.HasPrefix(result.Message, "current value: 3.000000\n") {
+		t.Errorf("expected three series, got message %q", result.Message)
+	}
+	received := g.received()
+	if len(received) != 1 || received[0].Path != "/tags/findSeries" {
+		t.Fatalf("expected a request to /tags/findSeries, got %v", received)
+	}
+	if exprs := received[0].Query()["expr"]; len(exprs) != 2 || exprs[0] != "name=cpu.load" || exprs[1] != "dc=~.*" {
+		t.Errorf("got expressions %v", exprs)
+	}
+}
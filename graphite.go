@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// graphite queries the render api of a graphite compatible server.
+	graphite struct {
+		r       *runner
+		pool    *endpointPool
+		retries int
+	}
+
+	// Result is the json response of the graphite render api.
+	Result []struct {
+		Target     string
+		Tags       map[string]string
+		Datapoints [][]*float64
+	}
+)
+
+func newGraphite(r *runner, pool *endpointPool, retries int) *graphite {
+	return &graphite{r: r, pool: pool, retries: retries}
+}
+
+// get sends the request built from the url of an endpoint to the pool.
+func (g *graphite) get(ctx context.Context, build func(base url.URL) string) ([]byte, error) {
+	return g.pool.do(ctx, func(e *endpoint) ([]byte, error) {
+		return g.r.get(ctx, e, "graphite", build(*e.url), g.retries)
+	})
+}
+
+// post sends the form to the path of an endpoint in the pool. Posted requests
+// are never cached.
+func (g *graphite) post(ctx context.Context, path string, form url.Values) ([]byte, error) {
+	return g.pool.do(ctx, func(e *endpoint) ([]byte, error) {
+		u := *e.url
+		u.Path = u.Path + path
+		return g.r.fetch(ctx, e, "graphite", u.String(), form.Encode(), g.retries)
+	})
+}
+
+// renderForm returns the form to fetch the targets over the last interval.
+func renderForm(interval string, targets ...string) url.Values {
+	return url.Values{
+		"format": {"json"},
+		"target": targets,
+		"from":   {"-" + interval},
+	}
+}
+
+// renderURL returns the url to fetch the targets over the last interval.
+func renderURL(u url.URL, interval string, targets ...string) string {
+	u.Path = u.Path + "/render"
+	query := u.Query()
+	query.Set("format", "json")
+	for _, target := range targets {
+		query.Add("target", target)
+	}
+	query.Set("from", "-"+interval)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (g *graphite) Fetch(ctx context.Context, target, interval string) ([]Series, error) {
+	if g.r.batcher != nil {
+		return g.r.batcher.fetch(ctx, g, target, interval)
+	}
+	raw, err := g.get(ctx, func(base url.URL) string {
+		return renderURL(base, interval, target)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseRender(raw)
+}
+
+// rangeURL returns the url to fetch the target between from and until.
+func rangeURL(u url.URL, target string, from, until time.Time) string {
+	u.Path = u.Path + "/render"
+	query := u.Query()
+	query.Set("format", "json")
+	query.Add("target", target)
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// FetchRange requests the target directly, as the batcher only combines
+// requests over the same last interval.
+func (g *graphite) FetchRange(ctx context.Context, target string, from, until time.Time) ([]Series, error) {
+	raw, err := g.get(ctx, func(base url.URL) string {
+		return rangeURL(base, target, from, until)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseRender(raw)
+}
+
+// parseRender converts the json response of the render api into series.
+func parseRender(raw []byte) ([]Series, error) {
+	payload := Result{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("could not parse json content: %s\n%s", err, raw)
+	}
+
+	series := make([]Series, len(payload))
+	for i, target := range payload {
+		series[i].Name = target.Target
+		series[i].Tags = target.Tags
+		series[i].Points = make([]Point, 0, len(target.Datapoints))
+		for _, point := range target.Datapoints {
+			if len(point) == 0 {
+				continue
+			}
+			p := Point{Value: point[0]}
+			if len(point) > 1 && point[1] != nil {
+				p.Timestamp = int64(*point[1])
+			}
+			series[i].Points = append(series[i].Points, p)
+		}
+	}
+	return series, nil
+}
+
+// TagTarget returns a seriesByTag expression for the tag expressions.
+func (g *graphite) TagTarget(exprs []string) (string, error) {
+	quoted := make([]string, len(exprs))
+	for i, expr := range exprs {
+		if _, _, _, err := parseTagExpr(expr); err != nil {
+			return "", err
+		}
+		if strings.ContainsAny(expr, "'\\") {
+			return "", fmt.Errorf("tag expression '%s' must not contain quotes or backslashes", expr)
+		}
+		quoted[i] = "'" + expr + "'"
+	}
+	return "seriesByTag(" + strings.Join(quoted, ",") + ")", nil
+}
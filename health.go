@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+type (
+	// internalMetric is a metric of the graphite cluster itself with its own
+	// levels, given as target:warn:error.
+	internalMetric struct {
+		target    string
+		levelWarn float64
+		levelErr  float64
+	}
+
+	// internalMetrics is a flag which can be given multiple times.
+	internalMetrics []internalMetric
+
+	// healthLine is the result of a single part of the health check.
+	healthLine struct {
+		exitCode int
+		message  string
+	}
+)
+
+func (m *internalMetrics) String() string {
+	parts := make([]string, len(*m))
+	for i, metric := range *m {
+		parts[i] = fmt.Sprintf("%s:%g:%g", metric.target, metric.levelWarn, metric.levelErr)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set parses a metric in the form target:warn:error. The target itself may
+// contain colons.
+func (m *internalMetrics) Set(val string) error {
+	parts := strings.Split(val, ":")
+	if len(parts) < 3 {
+		return fmt.Errorf("internal metric '%s' must have the form target:warn:error", val)
+	}
+	levelErr, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return fmt.Errorf("could not parse error level of '%s': %s", val, err)
+	}
+	levelWarn, err := strconv.ParseFloat(parts[len(parts)-2], 64)
+	if err != nil {
+		return fmt.Errorf("could not parse warning level of '%s': %s", val, err)
+	}
+	*m = append(*m, internalMetric{
+		target:    strings.Join(parts[:len(parts)-2], ":"),
+		levelWarn: levelWarn,
+		levelErr:  levelErr,
+	})
+	return nil
+}
+
+// stateName returns the nagios name of the exit code.
+func stateName(exitCode int) string {
+	switch exitCode {
+	case 0:
+		return "OK"
+	case 1:
+		return "WARNING"
+	case 2:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// checkHealth checks the graphite cluster itself. The key or tags select the
+// canary metric, which must have a value in the interval. The time to render
+// it is checked against the latency levels. At last all internal metrics are
+// checked against their own levels.
+func checkHealth(ctx context.Context, backend Backend, opts *checkOptions) monzero.CheckResult {
+	lines := []healthLine{}
+
+	canary, err := renderTarget(backend, opts)
+	if err != nil {
+		return monzero.CheckResult{ExitCode: 3, Message: err.Error()}
+	}
+	traceFrom(ctx).Target = canary
+	start := time.Now()
+	series, err := backend.Fetch(ctx, canary, opts.interval)
+	latency := time.Since(start)
+	if err != nil {
+		lines = append(lines, healthLine{2, fmt.Sprintf("could not render canary %s: %s", canary, err)})
+	} else {
+		lines = append(lines, canaryLine(series, canary, opts.interval))
+
+		line := healthLine{0, fmt.Sprintf("render latency: %s", latency.Round(time.Millisecond))}
+		if opts.latencyErr > 0 && latency >= opts.latencyErr {
+			line.exitCode = 2
+		} else if opts.latencyWarn > 0 && latency >= opts.latencyWarn {
+			line.exitCode = 1
+		}
+		lines = append(lines, line)
+	}
+
+	for _, metric := range opts.internal {
+		series, err := backend.Fetch(ctx, metric.target, opts.interval)
+		if err != nil {
+			lines = append(lines, healthLine{3, fmt.Sprintf("%s: %s", metric.target, err)})
+			continue
+		}
+		exitCode, curVal := evaluate(series, metric.levelWarn, metric.levelErr)
+		if curVal == nil {
+			lines = append(lines, healthLine{3, fmt.Sprintf("%s: no values received", metric.target)})
+			continue
+		}
+		lines = append(lines, healthLine{exitCode, fmt.Sprintf("%s: "+opts.message, metric.target, *curVal)})
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].exitCode > lines[j].exitCode })
+	result := monzero.CheckResult{}
+	msg := strings.Builder{}
+	for _, line := range lines {
+		if line.exitCode > result.ExitCode {
+			result.ExitCode = line.exitCode
+		}
+		fmt.Fprintf(&msg, "[%s] %s\n", stateName(line.exitCode), line.message)
+	}
+	result.Message = msg.String()
+	return result
+}
+
+// canaryLine checks that the canary has at least one value in the interval and
+// reports the age of the latest one.
+func canaryLine(series []Series, key, interval string) healthLine {
+	var last int64
+	for _, s := range series {
+		for _, point := range s.Points {
+			if point.Value != nil && point.Timestamp > last {
+				last = point.Timestamp
+			}
+		}
+	}
+	if last == 0 {
+		return healthLine{2, fmt.Sprintf("canary %s: no value written in the last %s", key, interval)}
+	}
+	age := time.Since(time.Unix(last, 0)).Round(time.Second)
+	return healthLine{0, fmt.Sprintf("canary %s: last value written %s ago", key, age)}
+}
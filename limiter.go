@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// requestLimiter limits the number of concurrent requests and the rate of
+// requests sent to a single server. Waiting for a request is bound to the
+// context of the check, so the time spent in the queue counts against the
+// timeout of the check.
+type requestLimiter struct {
+	slots chan struct{} // slots is nil when the concurrency is not limited
+
+	rate   float64 // rate is the number of requests per second, 0 disables it
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRequestLimiter returns a limiter for the maximum number of requests in
+// flight and the requests per second. It returns nil when both are 0.
+func newRequestLimiter(maxInFlight int, rate float64) *requestLimiter {
+	if maxInFlight <= 0 && rate <= 0 {
+		return nil
+	}
+	l := &requestLimiter{rate: rate}
+	if maxInFlight > 0 {
+		l.slots = make(chan struct{}, maxInFlight)
+	}
+	if rate > 0 {
+		l.burst = math.Max(1, rate)
+		l.tokens = l.burst
+		l.last = time.Now()
+	}
+	return l
+}
+
+// acquire waits until the request may be sent. The returned function must be
+// called when the request is done.
+func (l *requestLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a free request slot: %s", ctx.Err())
+	}
+}
+
+// wait takes a token from the bucket and waits for it to refill if empty.
+func (l *requestLimiter) wait(ctx context.Context) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("waiting for the rate limit: %s", ctx.Err())
+		}
+	}
+}
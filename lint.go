@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// lintChecks parses the command line of every check of the checker and writes
+// the problems and warnings found to w. It returns the number of checks with
+// problems, warnings do not count.
+func lintChecks(db *sql.DB, checkerID int, pools *poolRegistry, w io.Writer) (int, error) {
+	rows, err := db.Query(`select check_id, cmdLine
+		from active_checks
+		where checker_id = $1
+		order by check_id`, checkerID)
+	if err != nil {
+		return 0, fmt.Errorf("could not get checks: %s", err)
+	}
+	defer rows.Close()
+
+	checks, failed := 0, 0
+	for rows.Next() {
+		var (
+			id      int64
+			command []string
+		)
+		if err := rows.Scan(&id, pq.Array(&command)); err != nil {
+			return failed, fmt.Errorf("could not read check: %s", err)
+		}
+		checks++
+		problems, warnings := lintCommand(command, pools)
+		for _, problem := range problems {
+			fmt.Fprintf(w, "check %d: %s\n", id, problem)
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "check %d: warning: %s\n", id, warning)
+		}
+		if len(problems) > 0 {
+			failed++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return failed, fmt.Errorf("could not read checks: %s", err)
+	}
+	fmt.Fprintf(w, "%d checks, %d with problems\n", checks, failed)
+	return failed, nil
+}
+
+// lintCommand returns the problems of a check command line, which would make
+// the check fail or behave unexpectedly when run by the daemon, and warnings
+// about settings which are valid but often not intended.
+func lintCommand(command []string, pools *poolRegistry) ([]string, []string) {
+	if len(command) == 0 {
+		return []string{"empty command line"}, nil
+	}
+	fs := flag.NewFlagSet("check_graphite", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts := registerCheckFlags(fs)
+	if err := fs.Parse(command[1:]); err != nil {
+		return []string{fmt.Sprintf("could not parse arguments: %s", err)}, nil
+	}
+
+	problems, warnings := []string{}, []string{}
+	if fs.NArg() > 0 {
+		problems = append(problems, fmt.Sprintf("unexpected arguments %s", strings.Join(fs.Args(), " ")))
+	}
+	problems = append(problems, lintAddr(opts, pools)...)
+
+	switch opts.backend {
+	case "", "graphite", "prometheus":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown backend '%s'", opts.backend))
+	}
+	switch opts.mode {
+	case "", "render", "find", "health", "anomaly":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown mode '%s'", opts.mode))
+	}
+	if opts.mode == "find" && opts.backend == "prometheus" {
+		problems = append(problems, "find mode is not supported by prometheus")
+	}
+
+	if opts.interval == "" {
+		problems = append(problems, "no interval given")
+	} else if _, err := parseInterval(opts.interval); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if opts.key == "" && len(opts.tags) == 0 {
+		problems = append(problems, "no key given")
+	}
+	if opts.key != "" && len(opts.tags) > 0 {
+		problems = append(problems, "key and tags can not be used together")
+	}
+	for _, expr := range opts.tags {
+		if _, _, _, err := parseTagExpr(expr); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	// Equal levels are a valid check without warnings, but often a mistake.
+	if opts.mode != "health" && opts.levelWarn == opts.levelErr {
+		warnings = append(warnings, fmt.Sprintf("warning and error level are both %g, the check never warns", opts.levelErr))
+	}
+	if opts.latencyWarn > 0 && opts.latencyErr > 0 && opts.latencyErr < opts.latencyWarn {
+		problems = append(problems, "latency error level is below the warning level")
+	}
+	if opts.retries < 0 {
+		problems = append(problems, "retries must not be negative")
+	}
+	if opts.mode == "anomaly" {
+		if opts.days < minBaselineDays {
+			problems = append(problems, fmt.Sprintf("days must be at least %d", minBaselineDays))
+		}
+		if opts.baseline != "median" && opts.baseline != "mean" {
+			problems = append(problems, fmt.Sprintf("unknown baseline '%s'", opts.baseline))
+		}
+	}
+	if opts.replay != "" {
+		problems = append(problems, "replay does not ask the server and is only meant for the command line")
+	}
+	if opts.record != "" && opts.mode != "" && opts.mode != "render" {
+		problems = append(problems, "record only works in render mode")
+	}
+	if problem := lintMessage(opts.message); problem != "" {
+		problems = append(problems, problem)
+	}
+	return problems, warnings
+}
+
+// lintAddr checks that the address or server of the check can be resolved.
+func lintAddr(opts *checkOptions, pools *poolRegistry) []string {
+	if opts.addr != "" && opts.server != "" {
+		return []string{"addr and server can not be used together"}
+	}
+	if opts.server != "" {
+		if !pools.isNamed(opts.server) {
+			return []string{fmt.Sprintf("unknown server '%s'", opts.server)}
+		}
+		return nil
+	}
+	if opts.addr == "" {
+		return []string{"no address given to check"}
+	}
+	if pools.isNamed(opts.addr) {
+		return nil
+	}
+
+	problems := []string{}
+	for _, addr := range strings.Split(opts.addr, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" || pools.isNamed(addr) {
+			continue
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("could not parse addr '%s': %s", addr, err))
+			continue
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			problems = append(problems, fmt.Sprintf("addr '%s' must be an http or https url", addr))
+		} else if u.Host == "" {
+			problems = append(problems, fmt.Sprintf("addr '%s' has no host", addr))
+		}
+	}
+	return problems
+}
+
+// lintMessage checks that the message template formats exactly one number.
+func lintMessage(message string) string {
+	formatted := fmt.Sprintf(message, 1.5)
+	if strings.Contains(formatted, "%!") {
+		return fmt.Sprintf("message template '%s' must contain exactly one number verb like %%f, got '%s'", message, formatted)
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestLintCommand(t *testing.T) {
+	pools := newPoolRegistry(map[string]ClusterConfig{"prod": {Addrs: []string{"http://a", "http://b"}}}, nil)
+	tests := []struct {
+		name     string
+		command  []string
+		problems []string
+		warnings []string
+	}{
+		{
+			name:    "valid",
+			command: []string{"check_graphite", "-addr", "http://graphite", "-key", "a.b", "-warn", "1", "-error", "2"},
+		},
+		{
+			name:    "cluster",
+			command: []string{"check_graphite", "-addr", "prod", "-tags", "dc=fra", "-warn", "2", "-error", "1"},
+		},
+		{
+			name:     "unknown flag",
+			command:  []string{"check_graphite", "-addr", "http://graphite", "-bogus"},
+			problems: []string{"could not parse arguments: flag provided but not defined: -bogus"},
+		},
+		{
+			name:    "everything wrong",
+			command: []string{"check_graphite", "-server", "test", "-interval", "5x", "-message", "value %d", "extra"},
+			problems: []string{
+				"unexpected arguments extra",
+				"unknown server 'test'",
+				"unknown unit in interval '5x'",
+				"no key given",
+				"message template 'value %d' must contain exactly one number verb like %f, got 'value %!d(float64=1.5)'",
+			},
+			warnings: []string{"warning and error level are both 0, the check never warns"},
+		},
+		{
+			name:     "critical only",
+			command:  []string{"check_graphite", "-addr", "http://graphite", "-key", "a.b", "-warn", "5", "-error", "5"},
+			warnings: []string{"warning and error level are both 5, the check never warns"},
+		},
+		{
+			name:    "interval unit prefix",
+			command: []string{"check_graphite", "-addr", "http://graphite", "-key", "a.b", "-interval", "1hr", "-warn", "1", "-error", "2"},
+		},
+		{
+			name:    "default backend",
+			command: []string{"check_graphite", "-addr", "http://graphite", "-backend", "", "-key", "a.b", "-warn", "1", "-error", "2"},
+		},
+		{
+			name:     "addr without scheme",
+			command:  []string{"check_graphite", "-addr", "http://a,graphite:8080", "-key", "a.b", "-warn", "1", "-error", "2"},
+			problems: []string{"addr 'graphite:8080' must be an http or https url"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems, warnings := lintCommand(test.command, pools)
+			if strings.Join(problems, "\n") != strings.Join(test.problems, "\n") {
+				t.Errorf("got problems\n%s\nexpected\n%s", strings.Join(problems, "\n"), strings.Join(test.problems, "\n"))
+			}
+			if strings.Join(warnings, "\n") != strings.Join(test.warnings, "\n") {
+				t.Errorf("got warnings\n%s\nexpected\n%s", strings.Join(warnings, "\n"), strings.Join(test.warnings, "\n"))
+			}
+		})
+	}
+}
+
+func TestLintChecks(t *testing.T) {
+	fake := &fakeDB{queries: []fakeQuery{{
+		match:   "from active_checks",
+		columns: []string{"check_id", "cmdline"},
+		rows: [][]driver.Value{
+			{int64(1), "{check_graphite,-addr,http://graphite,-key,a.b,-warn,1,-error,2}"},
+			{int64(2), "{check_graphite,-key,a.b,-warn,1,-error,2}"},
+			{int64(3), "{check_graphite,-addr,http://graphite,-key,a.b,-warn,2,-error,2}"},
+		},
+	}}}
+	report := &strings.Builder{}
+	failed, err := lintChecks(fake.open(t), 1, newPoolRegistry(nil, nil), report)
+	if err != nil {
+		t.Fatalf("could not lint checks: %s", err)
+	}
+	if failed != 1 {
+		t.Errorf("got %d checks with problems, expected 1", failed)
+	}
+	expected := "check 2: no address given to check\n" +
+		"check 3: warning: warning and error level are both 2, the check never warns\n" +
+		"3 checks, 1 with problems\n"
+	if report.String() != expected {
+		t.Errorf("got report %q, expected %q", report.String(), expected)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// LogConfig configures the logging of the daemon.
+type LogConfig struct {
+	// Format is either text or json.
+	Format string `toml:"format"`
+	// Level is one of debug, info, warn or error.
+	Level string `toml:"level"`
+}
+
+// newLogger returns a logger writing to w in the configured format. The level
+// defaults to info.
+func newLogger(cfg LogConfig, w io.Writer) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("could not parse log level '%s': %s", cfg.Level, err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch cfg.Format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format '%s'", cfg.Format)
+	}
+}
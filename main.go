@@ -7,14 +7,13 @@ import (
 	"crypto/x509"
 	"database/sql"
 	"database/sql/driver"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,31 +27,47 @@ import (
 var (
 	configPath = flag.String("config", "check_graphite.conf", "path to the config file")
 	daemon     = flag.Bool("daemon", false, "run as a daemon, requires a config file")
-	addr       = flag.String("addr", "", "Set the address of the graphite server to use.")
-	levelWarn  = flag.Float64("warn", 0, "Set the level when it should be a warning.")
-	levelErr   = flag.Float64("error", 0, "Set the level when it should be an error")
-	key        = flag.String("key", "", "The key to check for the levels")
 	insecure   = flag.Bool("insecure", false, "Ignore SSL errors when sending requests")
-	message    = flag.String("message", "current value: %f", "Create a result message based on the template. Use %f to place the numeric value. To write the % sign, write %%")
+	logLevel   = flag.String("log-level", "", "Set the log level to debug, info, warn or error. Overrides the config file.")
+	logFormat  = flag.String("log-format", "", "Set the log format to text or json. Overrides the config file.")
+	dryRunMode = flag.Bool("dry-run", false, "With -daemon, run the due checks once without writing any results and report the checks changing their state.")
+	cliCheck   = registerCheckFlags(flag.CommandLine)
 )
 
 type (
 	Config struct {
-		DB        string `toml:"db"`
-		CheckerID int    `toml:"checker_id"`
-		Wait      int    `toml:"wait_duration"`
-		Jobs      int    `toml:"jobs"`
+		DB              string  `toml:"db"`
+		CheckerID       int     `toml:"checker_id"`
+		Wait            int     `toml:"wait_duration"`
+		Jobs            int     `toml:"jobs"`
+		AdminAddr       string  `toml:"admin_addr"`
+		ReadyURL        string  `toml:"ready_url"`
+		StaleAfter      int     `toml:"stale_after"`
+		CacheTTL        int     `toml:"cache_ttl"`
+		BatchWindow     int     `toml:"batch_window_ms"`
+		BatchTargets    int     `toml:"batch_targets"`
+		CircuitFailures int     `toml:"circuit_failures"`
+		CircuitProbe    int     `toml:"circuit_probe_interval"`
+		MaxInFlight     int     `toml:"max_in_flight"`
+		RateLimit       float64 `toml:"rate_limit"`
+		SleepUntilNext  bool    `toml:"sleep_until_next"`
+		NotifyChannel   string  `toml:"notify_channel"`
+
+		Database DBConfig                 `toml:"database"`
+		Carbon   CarbonConfig             `toml:"carbon"`
+		Log      LogConfig                `toml:"log"`
+		Clusters map[string]ClusterConfig `toml:"clusters"`
+		Servers  map[string]ServerConfig  `toml:"servers"`
 	}
 
 	States []int
 )
 
-type (
-	Result []struct{ Datapoints [][]*float64 }
-)
-
 func main() {
 	flag.Parse()
+	// command is the optional subcommand given after the flags.
+	command := flag.Arg(0)
+	needsDB := *daemon || command == "lint" || command == "run-check"
 	var (
 		config Config
 		db     *sql.DB
@@ -60,17 +75,35 @@ func main() {
 
 	hostname, err := os.Hostname()
 	if err != nil {
-		log.Fatalf("could not resolve hostname: %s", err)
+		Unknown("could not resolve hostname: %s", err)
 	}
 
-	if *daemon {
+	// The command line mode only needs the config for the named servers, so
+	// a missing config file is fine unless it was given explicitly.
+	if needsDB || configGiven() || fileExists(*configPath) {
 		if _, err := toml.DecodeFile(*configPath, &config); err != nil {
 			Unknown("could not parse config file: %s", err)
 		}
-		db, err = sql.Open("postgres", config.DB)
+	}
+
+	if *logLevel != "" {
+		config.Log.Level = *logLevel
+	}
+	if *logFormat != "" {
+		config.Log.Format = *logFormat
+	}
+	logger, err := newLogger(config.Log, os.Stderr)
+	if err != nil {
+		Unknown("could not create logger: %s", err)
+	}
+	slog.SetDefault(logger)
+
+	if needsDB {
+		db, err = openDB(config.DB, config.Database)
 		if err != nil {
-			Unknown("could not open database connection: %s", err)
+			Unknown("%s", err)
 		}
+		slog.Info("connected to database", "max open", config.Database.MaxOpen, "max idle", config.Database.MaxIdle)
 	}
 
 	rootCAs, _ := x509.SystemCertPool()
@@ -85,17 +118,86 @@ func main() {
 	tr := &http.Transport{TLSClientConfig: tlsConfig}
 	client := &http.Client{Transport: tr}
 
+	servers, err := newServers(config.Servers, tlsConfig)
+	if err != nil {
+		Unknown("%s", err)
+	}
+	pools := newPoolRegistry(config.Clusters, servers)
+	if *daemon {
+		if config.CircuitProbe <= 0 {
+			config.CircuitProbe = 30
+		}
+		pools.breakerThreshold = config.CircuitFailures
+		pools.probeInterval = time.Duration(config.CircuitProbe) * time.Second
+		pools.maxInFlight = config.MaxInFlight
+		pools.rateLimit = config.RateLimit
+	}
+
+	switch command {
+	case "":
+	case "lint":
+		failed, err := lintChecks(db, config.CheckerID, pools, os.Stdout)
+		if err != nil {
+			Unknown("%s", err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "run-check":
+		fs := flag.NewFlagSet("run-check", flag.ExitOnError)
+		id := fs.Int64("id", 0, "the id of the check to run")
+		write := fs.Bool("write", false, "store the result and create the notifications like the daemon")
+		fs.Parse(flag.Args()[1:])
+		r := &runner{client: client, pools: pools}
+		result, err := runCheckByID(db, *id, r, *write, hostname, os.Stdout)
+		if err != nil {
+			Unknown("%s", err)
+		}
+		fmt.Printf("result: %s\n%s\n", stateName(result.ExitCode), result.Message)
+		os.Exit(result.ExitCode)
+	case "backtest":
+		fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+		opts := registerCheckFlags(fs)
+		span := fs.String("range", "30d", "the range of the history to check")
+		step := fs.Duration("step", time.Minute, "the time between two runs of the check")
+		fs.Parse(flag.Args()[1:])
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		r := &runner{client: client, pools: pools}
+		if err := r.runBacktest(ctx, opts, *span, *step, os.Stdout); err != nil {
+			Unknown("%s", err)
+		}
+		os.Exit(0)
+	case "suggest":
+		fs := flag.NewFlagSet("suggest", flag.ExitOnError)
+		opts := registerCheckFlags(fs)
+		sopts := suggestOptions{}
+		span := fs.String("range", "30d", "the range of the history to base the levels on")
+		step := fs.Duration("step", time.Minute, "the time between two runs of the check")
+		fs.StringVar(&sopts.method, "method", "percentile", "derive the levels from a percentile of the runs or from the mean and the standard deviation with stddev")
+		fs.BoolVar(&sopts.lower, "lower", false, "lower values are worse")
+		fs.Float64Var(&sopts.warnRate, "warn-rate", 0.01, "the share of runs which should end in a warning with the percentile method")
+		fs.Float64Var(&sopts.errRate, "error-rate", 0.001, "the share of runs which should end in an error with the percentile method")
+		fs.Float64Var(&sopts.kWarn, "k-warn", 2, "the number of standard deviations to the warning level with the stddev method")
+		fs.Float64Var(&sopts.kErr, "k-error", 3, "the number of standard deviations to the error level with the stddev method")
+		fs.Parse(flag.Args()[1:])
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		r := &runner{client: client, pools: pools}
+		if err := r.runSuggest(ctx, opts, sopts, *span, *step, os.Stdout); err != nil {
+			Unknown("%s", err)
+		}
+		os.Exit(0)
+	default:
+		Unknown("unknown command '%s'", command)
+	}
+
 	if !*daemon {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		r := runner{client: client}
-		result := r.runCheck(
-			monzero.Check{
-				Command:   os.Args,
-				ExitCodes: []int{},
-			},
-			ctx,
-		)
+		r := runner{client: client, pools: pools}
+		result := r.run(ctx, cliCheck)
 		fmt.Println(result.Message)
 		os.Exit(result.ExitCode)
 	}
@@ -103,164 +205,479 @@ func main() {
 	if config.Jobs == 0 {
 		config.Jobs = 4
 	}
+	var carbon *carbonWriter
+	if config.Carbon.Addr != "" && !*dryRunMode {
+		carbon, err = newCarbonWriter(config.Carbon)
+		if err != nil {
+			Unknown("could not start carbon writer: %s", err)
+		}
+	}
+	if config.StaleAfter == 0 {
+		config.StaleAfter = 300
+		if limit := 2 * (config.Wait + 30); limit > config.StaleAfter {
+			config.StaleAfter = limit
+		}
+	}
+	var cache *responseCache
+	if config.CacheTTL > 0 {
+		cache = newResponseCache(time.Duration(config.CacheTTL) * time.Second)
+	}
+	var batcher *renderBatcher
+	if config.BatchWindow > 0 {
+		if config.BatchTargets <= 0 {
+			config.BatchTargets = 100
+		}
+		batcher = newRenderBatcher(
+			time.Duration(config.BatchWindow)*time.Millisecond,
+			config.BatchTargets,
+			30*time.Second,
+		)
+	}
+	if *dryRunMode {
+		r := &runner{client: client, cache: cache, batcher: batcher, pools: pools}
+		if err := dryRun(db, config.CheckerID, r, config.Jobs, os.Stdout); err != nil {
+			Unknown("%s", err)
+		}
+		os.Exit(0)
+	}
+	// monzero.NewChecker does not take over the checker id of its config, so
+	// the workers would run the checks of checker 0 instead of the configured
+	// ones.
+	if config.CheckerID != 0 {
+		Unknown("checker_id %d is not supported, the vendored monzero only runs the checks of checker 0", config.CheckerID)
+	}
+	var wake *waker
+	if config.SleepUntilNext || config.NotifyChannel != "" {
+		wake = newWaker(db, config.CheckerID, time.Duration(config.Wait)*time.Second, config.SleepUntilNext)
+		if config.NotifyChannel != "" {
+			if err := wake.listen(config.DB, config.NotifyChannel); err != nil {
+				Unknown("could not listen on channel %s: %s", config.NotifyChannel, err)
+			}
+		}
+	}
+	status := newWorkerStatus(config.Jobs)
+	dbState := &dbMonitor{}
+	if config.AdminAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler(db, dbState, config.Jobs))
+		mux.Handle("/healthz", healthzHandler(status, time.Duration(config.StaleAfter)*time.Second))
+		mux.Handle("/readyz", readyzHandler(db, client, config.ReadyURL))
+		go func() {
+			if err := http.ListenAndServe(config.AdminAddr, mux); err != nil {
+				slog.Error("could not start admin listener", "addr", config.AdminAddr, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 	wg := &sync.WaitGroup{}
 	for i := 0; i < config.Jobs; i++ {
 		wg.Add(1)
 		go func(thread int) {
+			workerLogger := logger.With("worker", thread)
 			r := &runner{
-				client: client,
+				client:  client,
+				carbon:  carbon,
+				cache:   cache,
+				batcher: batcher,
+				pools:   pools,
+				logger:  workerLogger,
 			}
 			checker, err := monzero.NewChecker(monzero.CheckerConfig{
+				CheckerID:      config.CheckerID,
 				DB:             db,
 				Timeout:        30 * time.Second,
 				HostIdentifier: hostname,
 				Executor:       r.runCheck,
+				Logger:         workerLogger,
 			})
 			if err != nil {
-				log.Fatalf("could not start checker: %s", err)
+				slog.Error("could not start checker", "error", err)
+				os.Exit(1)
 			}
-			for {
-				if err := checker.Next(); err != nil {
-					if err != monzero.ErrNoCheck {
-						log.Printf("error when getting the next check: %s", err)
-					}
-					time.Sleep(time.Duration(config.Wait) * time.Second)
-				}
+			idle := func() { time.Sleep(time.Duration(config.Wait) * time.Second) }
+			if wake != nil {
+				idle = wake.sleep
 			}
+			work(context.Background(), thread, checker, status, dbState, idle)
 		}(i)
 	}
 	wg.Wait()
 }
 
+// work runs the checks of the checker until the context is done. After a
+// database error the worker backs off and when no check is due, idle is called.
+func work(ctx context.Context, thread int, checker *monzero.Checker, status *workerStatus, dbState *dbMonitor, idle func()) {
+	backoff := time.Duration(0)
+	for ctx.Err() == nil {
+		status.beat(thread)
+		err := checker.Next()
+		if err != nil && err != monzero.ErrNoCheck {
+			stats.errors.Inc("")
+			backoff = dbState.failed(thread, err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		backoff = 0
+		dbState.succeeded()
+		status.nextDone()
+		if err == monzero.ErrNoCheck {
+			stats.idle.Inc("")
+			idle()
+		}
+	}
+}
+
 type (
 	runner struct {
 		client *http.Client
+		carbon *carbonWriter  // carbon receives the results when set
+		cache  *responseCache // cache is shared by all workers when set
+		// batcher combines the render requests of all workers when set
+		batcher *renderBatcher
+		pools   *poolRegistry
+		logger  *slog.Logger // logger defaults to the default logger when nil
+	}
+
+	// checkOptions contains the settings of a single check as given on its
+	// command line.
+	checkOptions struct {
+		addr       string
+		roundRobin bool
+		server     string
+		backend    string
+		interval   string
+		levelWarn  float64
+		levelErr   float64
+		key        string
+		tags       stringList
+		groupBy    string
+		mode       string
+		name       string
+		retries    int
+		message    string
+		explain    bool
+		record     string
+		replay     string
+		days       int
+		baseline   string
+
+		latencyWarn time.Duration
+		latencyErr  time.Duration
+		internal    internalMetrics
 	}
 )
 
-func (r *runner) runCheck(check monzero.Check, ctx context.Context) monzero.CheckResult {
-	result := monzero.CheckResult{ExitCode: 3}
+// registerCheckFlags defines the flags of a check on fs. The same set is used
+// for the command line and the checks run by the daemon.
+func registerCheckFlags(fs *flag.FlagSet) *checkOptions {
+	opts := &checkOptions{}
+	fs.StringVar(&opts.addr, "addr", "", "Set the address of the graphite server to use. Can be a comma separated list of replicated servers or the name of a cluster from the config.")
+	fs.StringVar(&opts.server, "server", "", "Use the named server or cluster from the config file instead of an address.")
+	fs.BoolVar(&opts.roundRobin, "round-robin", false, "Spread the requests over all servers given in addr instead of only failing over.")
+	fs.StringVar(&opts.backend, "backend", "graphite", "Set the backend to query, either graphite or prometheus.")
+	fs.StringVar(&opts.interval, "interval", "60s", "Set the interval to use for checking")
+	fs.Float64Var(&opts.levelWarn, "warn", 0, "Set the level when it should be a warning.")
+	fs.Float64Var(&opts.levelErr, "error", 0, "Set the level when it should be an error")
+	fs.StringVar(&opts.key, "key", "", "The key to check for the levels")
+	fs.Var(&opts.tags, "tags", "Select the series by a tag expression like name=cpu.load instead of a key. Can be given multiple times.")
+	fs.StringVar(&opts.groupBy, "group-by", "", "Comma separated list of tags to check and report the series grouped by.")
+	fs.StringVar(&opts.mode, "mode", "render", "Set the mode of the check. render checks the values of the key, find checks the number of metrics matching the key, health checks the graphite cluster itself with the key as canary and anomaly checks the deviation of the key from the previous days in standard deviations.")
+	fs.StringVar(&opts.name, "name", "", "Set the name of the check in the metrics written to carbon. Defaults to the key.")
+	fs.IntVar(&opts.retries, "retries", 0, "the number of retries before the check is returned as failed")
+	fs.StringVar(&opts.message, "message", "current value: %f", "Create a result message based on the template. Use %f to place the numeric value. To write the % sign, write %%")
+	fs.BoolVar(&opts.explain, "explain", false, "Add the requests, the series and the points deciding the state to the message.")
+	fs.BoolVar(&opts.explain, "v", false, "Short for -explain.")
+	fs.StringVar(&opts.record, "record", "", "Save every render response as a file in the directory.")
+	fs.StringVar(&opts.replay, "replay", "", "Check the response saved in the file by -record instead of asking the server.")
+	fs.IntVar(&opts.days, "days", 7, "In anomaly mode, set the number of previous days the baseline is built from.")
+	fs.StringVar(&opts.baseline, "baseline", "median", "In anomaly mode, build the baseline from the median and the median absolute deviation or from the mean and the standard deviation with mean.")
+	fs.DurationVar(&opts.latencyWarn, "latency-warn", 0, "In health mode, set the render latency when it should be a warning.")
+	fs.DurationVar(&opts.latencyErr, "latency-error", 0, "In health mode, set the render latency when it should be an error.")
+	fs.Var(&opts.internal, "internal", "In health mode, check an internal metric of the cluster given as target:warn:error. Can be given multiple times.")
+	return opts
+}
 
+func (r *runner) runCheck(check monzero.Check, ctx context.Context) monzero.CheckResult {
 	fs := flag.NewFlagSet("check_graphite", flag.ContinueOnError)
-	addr := fs.String("addr", "", "Set the address of the graphite server to use.")
-	interval := fs.String("interval", "60s", "Set the interval to use for checking")
-	levelWarn := fs.Float64("warn", 0, "Set the level when it should be a warning.")
-	levelErr := fs.Float64("error", 0, "Set the level when it should be an error")
-	key := fs.String("key", "", "The key to check for the levels")
-	retries := fs.Int("retries", 0, "the number of retries before the check is returned as failed")
-	message := fs.String("message", "current value: %f", "Create a result message based on the template. Use %f to place the numeric value. To write the % sign, write %%")
+	fs.SetOutput(io.Discard)
+	opts := registerCheckFlags(fs)
 
 	if err := fs.Parse(check.Command[1:]); err != nil {
-		result.Message = fmt.Sprintf("could not parse arguments: %s", err)
-		return result
+		return monzero.CheckResult{
+			ExitCode: 3,
+			Message:  fmt.Sprintf("could not parse arguments: %s", err),
+		}
 	}
 
-	if *addr == "" {
-		result.Message = "no address given to check"
-		return result
+	return r.run(ctx, opts)
+}
+
+// log returns the logger of the runner.
+func (r *runner) log() *slog.Logger {
+	if r.logger == nil {
+		return slog.Default()
 	}
-	if *interval == "" {
-		result.Message = "no interval given"
-		return result
+	return r.logger
+}
+
+// run runs the check, records its stats and logs the details of the run.
+func (r *runner) run(ctx context.Context, opts *checkOptions) monzero.CheckResult {
+	stats.busy.Add(1)
+	defer stats.busy.Add(-1)
+	ctx, trace := withTrace(ctx)
+	trace.keepBody = opts.record != ""
+	start := time.Now()
+	result := r.check(ctx, opts)
+	duration := time.Since(start)
+	stats.checks.Inc(strconv.Itoa(result.ExitCode))
+
+	attrs := append([]any{"name", opts.metricName()}, trace.logAttrs()...)
+	attrs = append(attrs, "duration", duration, "exit code", result.ExitCode)
+	r.log().Debug("check finished", attrs...)
+	if opts.explain {
+		explanation := strings.Builder{}
+		trace.write(&explanation, opts.levelWarn, opts.levelErr, false)
+		result.Message += explanation.String()
 	}
-	if *key == "" {
-		result.Message = "no key given"
-		return result
+	if r.carbon != nil {
+		r.carbon.writeResult(opts.metricName(), trace.Value, result.ExitCode, duration)
 	}
+	return result
+}
+
+// metricName returns the name of the check used in metric paths.
+func (opts *checkOptions) metricName() string {
+	if opts.name != "" {
+		return metricName(opts.name)
+	}
+	if len(opts.tags) > 0 {
+		return metricName(strings.Join(opts.tags, "_"))
+	}
+	return metricName(opts.key)
+}
 
-	url, err := url.Parse(*addr)
+// check validates the options and runs the check in the selected mode.
+func (r *runner) check(ctx context.Context, opts *checkOptions) monzero.CheckResult {
+	result := monzero.CheckResult{ExitCode: 3}
+
+	if opts.replay != "" {
+		return replayRender(ctx, opts)
+	}
+	backend, err := r.prepare(opts)
 	if err != nil {
-		result.Message = fmt.Sprintf("could not parse addr '%s': %s", *addr, err)
+		result.Message = err.Error()
 		return result
 	}
-	url.Path = url.Path + "/render"
-	query := url.Query()
-	query.Set("format", "json")
-	query.Set("target", *key)
-	query.Set("from", "-"+*interval)
-	url.RawQuery = query.Encode()
 
-	var (
-		res *http.Response
-		raw []byte
-	)
-	success := false
+	switch opts.mode {
+	case "", "render":
+		result = checkRender(ctx, backend, opts)
+	case "find":
+		result = checkFind(ctx, backend, opts)
+	case "health":
+		result = checkHealth(ctx, backend, opts)
+	case "anomaly":
+		result = checkAnomaly(ctx, backend, opts)
+	default:
+		result.Message = fmt.Sprintf("unknown mode '%s'", opts.mode)
+		return result
+	}
+	if endpoint := traceFrom(ctx).Endpoint; endpoint != "" {
+		result.Message += "answered by " + endpoint + "\n"
+	}
+	return result
+}
 
-	for i := 0; i < *retries+1; i++ {
-		res, err = r.client.Get(url.String())
-		if err != nil {
-			result.Message = fmt.Sprintf("could not get result: %s", err)
-			return result
+// prepare validates the options and returns the backend to check.
+func (r *runner) prepare(opts *checkOptions) (Backend, error) {
+	if r.pools == nil {
+		r.pools = newPoolRegistry(nil, nil)
+	}
+	addr := opts.addr
+	if opts.server != "" {
+		if addr != "" {
+			return nil, fmt.Errorf("addr and server can not be used together")
 		}
-		defer res.Body.Close()
-
-		raw, err = ioutil.ReadAll(res.Body)
-		if err != nil {
-			result.Message = fmt.Sprintf("could not read content body: %s", err)
-			return result
+		if !r.pools.isNamed(opts.server) {
+			return nil, fmt.Errorf("unknown server '%s'", opts.server)
 		}
+		addr = opts.server
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("no address given to check")
+	}
+	if opts.interval == "" {
+		return nil, fmt.Errorf("no interval given")
+	}
+	if opts.key == "" && len(opts.tags) == 0 {
+		return nil, fmt.Errorf("no key given")
+	}
+	if opts.key != "" && len(opts.tags) > 0 {
+		return nil, fmt.Errorf("key and tags can not be used together")
+	}
 
-		// For some reason metrictank is unable to return any data when it goes into
-		// maintenance mode. There is no way to work around the issue, because of
-		// its architecture.
-		// So when it is not in the mood to return data, we just retry again.
-		if res.StatusCode > 500 {
-			continue
-		}
-		if res.StatusCode != http.StatusOK {
-			result.Message = fmt.Sprintf("graphite api answered with status code %d", res.StatusCode)
-			return result
+	pool, err := r.pools.pool(addr, opts.roundRobin)
+	if err != nil {
+		return nil, err
+	}
+	// The health check measures the server itself and recordings must contain
+	// the response to the check alone, so their requests must not be shared
+	// with other checks, neither through the cache nor in batches.
+	if opts.mode == "health" || opts.record != "" {
+		direct := *r
+		direct.cache = nil
+		direct.batcher = nil
+		r = &direct
+	}
+	return newBackend(opts.backend, r, pool, opts.retries)
+}
+
+// checkRender fetches the data for the configured key and checks it against
+// the warning and error levels.
+func checkRender(ctx context.Context, backend Backend, opts *checkOptions) monzero.CheckResult {
+	result := monzero.CheckResult{ExitCode: 3}
+
+	target, err := renderTarget(backend, opts)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	traceFrom(ctx).Target = target
+	series, err := backend.Fetch(ctx, target, opts.interval)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if opts.record != "" {
+		if err := record(opts, target, traceFrom(ctx)); err != nil {
+			slog.Warn("could not record the response", "name", opts.metricName(), "error", err)
 		}
-		success = true
-		break
+	}
+	return renderResult(ctx, series, opts)
+}
+
+// renderTarget returns the target selecting the series of the check.
+func renderTarget(backend Backend, opts *checkOptions) (string, error) {
+	if len(opts.tags) > 0 {
+		return backend.TagTarget(opts.tags)
+	}
+	return opts.key, nil
+}
+
+// renderResult checks the series against the warning and error levels.
+func renderResult(ctx context.Context, series []Series, opts *checkOptions) monzero.CheckResult {
+	result := monzero.CheckResult{ExitCode: 3}
+	traceFrom(ctx).Series = series
+	if opts.groupBy != "" {
+		return groupResult(series, opts)
 	}
 
-	if !success {
-		result.Message = fmt.Sprintf("graphite api has internal problems, answered with status code: %d", res.StatusCode)
+	exitCode, curVal := evaluate(series, opts.levelWarn, opts.levelErr)
+	if curVal == nil {
+		result.ExitCode = 2
+		result.Message = "No values received for query! Is the host down?"
 		return result
 	}
+	traceFrom(ctx).Value = curVal
+	result.ExitCode = exitCode
+	result.Message = fmt.Sprintf(opts.message+"\n", *curVal)
+	return result
+}
 
-	payload := Result{}
-	if err := json.Unmarshal(raw, &payload); err != nil {
-		result.Message = fmt.Sprintf("could not parse json content: %s\n%s", err, raw)
+// groupResult checks the series grouped by the tags in the group-by option.
+// The exit code is the worst of all groups and the message contains a line for
+// each group, starting with the worst.
+func groupResult(series []Series, opts *checkOptions) monzero.CheckResult {
+	result := monzero.CheckResult{}
+	if len(series) == 0 {
+		result.ExitCode = 2
+		result.Message = "No values received for query! Is the host down?"
 		return result
 	}
 
+	groups := groupSeries(series, strings.Split(opts.groupBy, ","))
+	lines := make([]string, len(groups))
+	codes := make([]int, len(groups))
+	for i, group := range groups {
+		exitCode, curVal := evaluate(group.Series, opts.levelWarn, opts.levelErr)
+		if curVal == nil {
+			codes[i] = 2
+			lines[i] = group.Label + ": no values received"
+		} else {
+			codes[i] = exitCode
+			lines[i] = fmt.Sprintf("%s: "+opts.message, group.Label, *curVal)
+		}
+		if codes[i] > result.ExitCode {
+			result.ExitCode = codes[i]
+		}
+	}
+
+	order := make([]int, len(groups))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return codes[order[i]] > codes[order[j]] })
+	msg := strings.Builder{}
+	for _, i := range order {
+		msg.WriteString(lines[i] + "\n")
+	}
+	result.Message = msg.String()
+	return result
+}
+
+// evaluate checks all points of the series against the levels and returns the
+// exit code and the worst value found. When the error level is below the
+// warning level, lower values are considered worse.
+// The returned value is nil when no point contained a value.
+func evaluate(series []Series, levelWarn, levelErr float64) (int, *float64) {
 	var curVal *float64
-	result.ExitCode = 0
-	for _, target := range payload {
-		for _, point := range target.Datapoints {
-			if point[0] == nil {
+	exitCode := 0
+	for _, s := range series {
+		for _, point := range s.Points {
+			if point.Value == nil {
 				continue
 			}
-			if *levelErr < *levelWarn {
-				if curVal == nil || *point[0] < *curVal {
-					curVal = point[0]
+			if levelErr < levelWarn {
+				if curVal == nil || *point.Value < *curVal {
+					curVal = point.Value
 				}
-				if *point[0] <= *levelErr && result.ExitCode != 1 {
-					result.ExitCode = 2
-				} else if *point[0] <= *levelWarn && result.ExitCode == 0 {
-					result.ExitCode = 1
+				if *point.Value <= levelErr && exitCode != 1 {
+					exitCode = 2
+				} else if *point.Value <= levelWarn && exitCode == 0 {
+					exitCode = 1
 				}
 			} else {
-				if curVal == nil || *point[0] > *curVal {
-					curVal = point[0]
+				if curVal == nil || *point.Value > *curVal {
+					curVal = point.Value
 				}
-				if *point[0] >= *levelErr && result.ExitCode != 1 {
-					result.ExitCode = 2
-				} else if *point[0] >= *levelWarn && result.ExitCode == 0 {
-					result.ExitCode = 1
+				if *point.Value >= levelErr && exitCode != 1 {
+					exitCode = 2
+				} else if *point.Value >= levelWarn && exitCode == 0 {
+					exitCode = 1
 				}
 			}
 		}
 	}
-	if curVal == nil {
-		result.ExitCode = 2
-		result.Message = "No values received for query! Is the host down?"
-		return result
-	}
-	result.Message = fmt.Sprintf(*message+"\n", *curVal)
-	return result
+	return exitCode, curVal
+}
+
+// configGiven returns true when the config file was set on the command line.
+func configGiven() bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			given = true
+		}
+	})
+	return given
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 func Unknown(msg string, args ...interface{}) {
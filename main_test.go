@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+func TestRunCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []fakeResponse
+		args      []string
+		timeout   time.Duration
+		code      int
+		message   string
+		requests  int // requests is the number of requests expected, 1 when not set
+	}{
+		{
+			name:      "upper levels ok",
+			responses: []fakeResponse{ok("a.b", 1.0, 2.0)},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      0,
+			message:   "current value: 2.000000\n",
+		},
+		{
+			name:      "upper levels warning",
+			responses: []fakeResponse{ok("a.b", 1.0, 6.0)},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      1,
+			message:   "current value: 6.000000\n",
+		},
+		{
+			name:      "upper levels error",
+			responses: []fakeResponse{ok("a.b", 1.0, 11.0, 2.0)},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      2,
+			message:   "current value: 11.000000\n",
+		},
+		{
+			name:      "lower levels warning",
+			responses: []fakeResponse{ok("a.b", 20.0, 8.0)},
+			args:      []string{"-warn", "10", "-error", "5"},
+			code:      1,
+			message:   "current value: 8.000000\n",
+		},
+		{
+			name:      "lower levels error",
+			responses: []fakeResponse{ok("a.b", 20.0, 3.0)},
+			args:      []string{"-warn", "10", "-error", "5"},
+			code:      2,
+			message:   "current value: 3.000000\n",
+		},
+		{
+			// Once a point raised a warning, later points do not raise it to
+			// an error.
+			name:      "warning before error",
+			responses: []fakeResponse{ok("a.b", 6.0, 11.0)},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      1,
+			message:   "current value: 11.000000\n",
+		},
+		{
+			name:      "nulls are skipped",
+			responses: []fakeResponse{ok("a.b", nil, 1.0, nil)},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      0,
+			message:   "current value: 1.000000\n",
+		},
+		{
+			name:      "only nulls",
+			responses: []fakeResponse{ok("a.b", nil, nil)},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      2,
+			message:   "No values received for query! Is the host down?",
+		},
+		{
+			name:      "no series",
+			responses: []fakeResponse{{body: "[]"}},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      2,
+			message:   "No values received for query! Is the host down?",
+		},
+		{
+			name:      "message template",
+			responses: []fakeResponse{ok("a.b", 42.0)},
+			args:      []string{"-warn", "50", "-error", "90", "-message", "disk usage at %.0f%%"},
+			code:      0,
+			message:   "disk usage at 42%\n",
+		},
+		{
+			name: "group by",
+			responses: []fakeResponse{{body: renderBody(
+				fakeSeries{target: "a", tags: map[string]string{"dc": "ber"}, values: []interface{}{1.0}},
+				fakeSeries{target: "b", tags: map[string]string{"dc": "fra"}, values: []interface{}{20.0}},
+			)}},
+			args:    []string{"-warn", "5", "-error", "10", "-group-by", "dc"},
+			code:    2,
+			message: "dc=fra: current value: 20.000000\ndc=ber: current value: 1.000000\n",
+		},
+		{
+			name:      "retry on unavailable",
+			responses: []fakeResponse{{status: http.StatusServiceUnavailable}, ok("a.b", 1.0)},
+			args:      []string{"-warn", "5", "-error", "10", "-retries", "1"},
+			code:      0,
+			message:   "current value: 1.000000\n",
+			requests:  2,
+		},
+		{
+			name:      "retries exhausted",
+			responses: []fakeResponse{{status: http.StatusBadGateway}},
+			args:      []string{"-warn", "5", "-error", "10", "-retries", "2"},
+			code:      3,
+			message:   "graphite api has internal problems, answered with status code: 502",
+			requests:  3,
+		},
+		{
+			name:      "no retry on internal server error",
+			responses: []fakeResponse{{status: http.StatusInternalServerError}},
+			args:      []string{"-warn", "5", "-error", "10", "-retries", "2"},
+			code:      3,
+			message:   "graphite api answered with status code 500",
+			requests:  1,
+		},
+		{
+			name:      "not found",
+			responses: []fakeResponse{{status: http.StatusNotFound}},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      3,
+			message:   "graphite api answered with status code 404",
+		},
+		{
+			name:      "malformed body",
+			responses: []fakeResponse{{body: `[{"target": "a.b", "datapoints": [[1.0,`}},
+			args:      []string{"-warn", "5", "-error", "10"},
+			code:      3,
+			message:   "could not parse json content",
+		},
+		{
+			name:      "slow response",
+			responses: []fakeResponse{{body: "[]", delay: time.Second}},
+			args:      []string{"-warn", "5", "-error", "10"},
+			timeout:   100 * time.Millisecond,
+			code:      3,
+			message:   "context deadline exceeded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newFakeGraphite(t, test.responses...)
+			timeout := test.timeout
+			if timeout == 0 {
+				timeout = 5 * time.Second
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			r := &runner{client: g.Client()}
+			command := append([]string{"check_graphite", "-addr", g.URL, "-key", "a.b"}, test.args...)
+			result := r.runCheck(monzero.Check{Command: command}, ctx)
+
+			if result.ExitCode != test.code {
+				t.Errorf("got exit code %d, expected %d with message %q", result.ExitCode, test.code, result.Message)
+			}
+			if result.Message != test.message && !strings.Contains(result.Message, test.message) {
+				t.Errorf("got message %q, expected %q", result.Message, test.message)
+			}
+			requests := test.requests
+			if requests == 0 {
+				requests = 1
+			}
+			if received := g.received(); len(received) != requests {
+				t.Errorf("got %d requests, expected %d", len(received), requests)
+			}
+		})
+	}
+}
+
+func TestRunCheckInvalidArguments(t *testing.T) {
+	r := &runner{}
+	command := []string{"check_graphite", "-addr", "http://localhost", "-key", "a.b", "-warn", "five"}
+	result := r.runCheck(monzero.Check{Command: command}, context.Background())
+	if result.ExitCode != 3 || !strings.HasPrefix(result.Message, "could not parse arguments") {
+		t.Errorf("got exit code %d and message %q", result.ExitCode, result.Message)
+	}
+}
+
+func TestRenderRequest(t *testing.T) {
+	g := newFakeGraphite(t, ok("a.b", 1.0))
+	r := &runner{client: g.Client()}
+	command := []string{"check_graphite", "-addr", g.URL, "-key", "a.b", "-interval", "5min", "-warn", "5", "-error", "10"}
+	r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	received := g.received()
+	if len(received) != 1 {
+		t.Fatalf("got %d requests, expected 1", len(received))
+	}
+	u := received[0]
+	if u.Path != "/render" {
+		t.Errorf("got path %s, expected /render", u.Path)
+	}
+	query := u.Query()
+	for key, expected := range map[string]string{"target": "a.b", "from": "-5min", "format": "json"} {
+		if got := query.Get(key); got != expected {
+			t.Errorf("got %s=%s, expected %s", key, got, expected)
+		}
+	}
+}
+
+func TestRunLogsRedactedURL(t *testing.T) {
+	g := newFakeGraphite(t, ok("a.b", 1.0))
+	logs := &strings.Builder{}
+	r := &runner{
+		client: g.Client(),
+		logger: slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})).With("worker", 3),
+	}
+	addr := strings.Replace(g.URL, "http://", "http://user:secret@", 1)
+	command := []string{"check_graphite", "-addr", addr, "-key", "a.b", "-warn", "5", "-error", "10"}
+	r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("password found in the logs: %s", logs)
+	}
+	if !strings.Contains(logs.String(), "worker=3") || !strings.Contains(logs.String(), "user:xxxxx@") {
+		t.Errorf("expected the worker and the redacted url in the logs: %s", logs)
+	}
+}
+
+func TestExplainRedactsURL(t *testing.T) {
+	g := newFakeGraphite(t, ok("a.b", 1.0))
+	r := &runner{client: g.Client()}
+	addr := strings.Replace(g.URL, "http://", "http://user:secret@", 1)
+	command := []string{"check_graphite", "-addr", addr, "-key", "a.b", "-warn", "5", "-error", "10", "-explain"}
+	result := r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	if strings.Contains(result.Message, "secret") {
+		t.Errorf("password found in the message: %s", result.Message)
+	}
+	if !strings.Contains(result.Message, "request: http://user:xxxxx@") {
+		t.Errorf("expected the redacted url in the message: %s", result.Message)
+	}
+}
+
+func TestHealthTaggedCanary(t *testing.T) {
+	g := newFakeGraphite(t, ok("canary", 1.0))
+	r := &runner{client: g.Client()}
+	command := []string{"check_graphite", "-addr", g.URL, "-mode", "health", "-tags", "name=canary", "-interval", "5min"}
+	result := r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	if result.ExitCode != 0 {
+		t.Errorf("got exit code %d with message %q", result.ExitCode, result.Message)
+	}
+	received := g.received()
+	if len(received) != 1 || received[0].Query().Get("target") != "seriesByTag('name=canary')" {
+		t.Errorf("expected the canary to be selected by its tags, got %v", received)
+	}
+}
+
+func TestCheckMetricName(t *testing.T) {
+	tests := []struct {
+		opts     checkOptions
+		expected string
+	}{
+		{checkOptions{key: "a.b.c"}, "a_b_c"},
+		{checkOptions{tags: []string{"name=cpu.load", "dc=fra"}}, "name_cpu_load_dc_fra"},
+		{checkOptions{key: "a.b", name: "web.frontend latency"}, "web_frontend_latency"},
+	}
+	for _, test := range tests {
+		if got := test.opts.metricName(); got != test.expected {
+			t.Errorf("got %s, expected %s", got, test.expected)
+		}
+	}
+}
+
+func TestWork(t *testing.T) {
+	g := newFakeGraphite(t, ok("a.b", 11.0))
+	fake := &fakeDB{
+		failBegin: 1,
+		queries: []fakeQuery{{
+			match:   "from active_checks",
+			columns: []string{"check_id", "cmdline", "states", "mapping_id"},
+			rows: [][]driver.Value{{
+				int64(7),
+				[]string{"check_graphite", "-addr", g.URL, "-key", "a.b", "-warn", "5", "-error", "10"},
+				[]int{0},
+				3,
+			}},
+		}},
+	}
+	r := &runner{client: g.Client()}
+	checker, err := monzero.NewChecker(monzero.CheckerConfig{
+		DB:             fake.open(t),
+		Timeout:        5 * time.Second,
+		HostIdentifier: "test",
+		Executor:       r.runCheck,
+		Logger:         slog.Default(),
+	})
+	if err != nil {
+		t.Fatalf("could not create checker: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dbState := &dbMonitor{}
+	idle := func() {
+		if !dbState.up() {
+			t.Error("database is still down after a successful run")
+		}
+		cancel()
+	}
+	work(ctx, 0, checker, newWorkerStatus(1), dbState, idle)
+
+	execs := fake.executed()
+	if len(execs) != 2 {
+		t.Fatalf("got %d statements, expected the update and the notification", len(execs))
+	}
+	update := execs[0]
+	if !strings.Contains(update.query, "update active_checks") {
+		t.Errorf("expected the update of the check, got %s", update.query)
+	}
+	if update.args[0] != int64(7) || update.args[1] != int64(2) || update.args[2] != "current value: 11.000000\n" {
+		t.Errorf("got update arguments %v", update.args)
+	}
+	if notify := execs[1]; notify.args[len(notify.args)-1] != "test" {
+		t.Errorf("got notification arguments %v", notify.args)
+	}
+	if fake.commits != 1 {
+		t.Errorf("got %d commits, expected 1", fake.commits)
+	}
+}
+
+func TestDBMonitorBackoff(t *testing.T) {
+	m := &dbMonitor{}
+	backoff := time.Duration(0)
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		backoff = m.failed(0, errors.New("connection refused"), backoff)
+		if backoff != expected {
+			t.Errorf("got backoff %s, expected %s", backoff, expected)
+		}
+	}
+	if m.failed(0, errors.New("connection refused"), time.Minute) != maxBackoff {
+		t.Errorf("backoff is not limited to %s", maxBackoff)
+	}
+	if m.up() {
+		t.Error("database is up after failures")
+	}
+	m.succeeded()
+	if !m.up() {
+		t.Error("database is down after success")
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		duration time.Duration
+		err      bool
+	}{
+		{interval: "60s", duration: time.Minute},
+		{interval: "30sec", duration: 30 * time.Second},
+		{interval: "5min", duration: 5 * time.Minute},
+		{interval: "5minutes", duration: 5 * time.Minute},
+		{interval: "5m", duration: 5 * time.Minute},
+		{interval: "1hr", duration: time.Hour},
+		{interval: "5hrs", duration: 5 * time.Hour},
+		{interval: "2days", duration: 48 * time.Hour},
+		{interval: "1w", duration: 7 * 24 * time.Hour},
+		{interval: "1mon", duration: 30 * 24 * time.Hour},
+		{interval: "1months", duration: 30 * 24 * time.Hour},
+		{interval: "1y", duration: 365 * 24 * time.Hour},
+		{interval: "5x", err: true},
+		{interval: "min", err: true},
+		{interval: "60", err: true},
+	}
+	for _, test := range tests {
+		d, err := parseInterval(test.interval)
+		if (err != nil) != test.err || d != test.duration {
+			t.Errorf("%s: got %s and error %v, expected %s", test.interval, d, err, test.duration)
+		}
+	}
+}
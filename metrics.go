@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// counter is a monotonic counter, optionally partitioned by the value of a
+	// single label.
+	counter struct {
+		name   string
+		help   string
+		label  string
+		mu     sync.Mutex
+		values map[string]float64
+	}
+
+	// histogram counts observations into cumulative buckets.
+	histogram struct {
+		name    string
+		help    string
+		buckets []float64
+		mu      sync.Mutex
+		counts  []uint64
+		sum     float64
+		count   uint64
+	}
+
+	// daemonStats contains all metrics the daemon exposes about itself.
+	daemonStats struct {
+		checks    *counter
+		renders   *histogram
+		retries   *counter
+		responses *counter
+		idle      *counter
+		errors    *counter
+		cache     *counter
+		circuit   *counter
+		busy      atomic.Int64 // busy is the number of workers running a check
+	}
+)
+
+// stats is updated by all workers and exposed by the metrics handler.
+var stats = &daemonStats{
+	checks:    newCounter("check_graphite_checks_total", "Number of checks run by exit code.", "exit_code"),
+	renders:   newHistogram("check_graphite_request_duration_seconds", "Duration of the requests to the backends.", []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}),
+	retries:   newCounter("check_graphite_retries_total", "Number of requests retried because of an internal error of the backend.", ""),
+	responses: newCounter("check_graphite_responses_total", "Number of responses from the backends by status code.", "code"),
+	idle:      newCounter("check_graphite_idle_total", "Number of times a worker found no check to run.", ""),
+	errors:    newCounter("check_graphite_next_errors_total", "Number of errors when getting or updating the next check.", ""),
+	cache:     newCounter("check_graphite_cache_requests_total", "Number of requests to the response cache by result.", "result"),
+	circuit:   newCounter("check_graphite_circuit_rejected_total", "Number of requests rejected by an open circuit breaker.", ""),
+}
+
+func newCounter(name, help, label string) *counter {
+	return &counter{name: name, help: help, label: label, values: map[string]float64{}}
+}
+
+// Inc increments the counter for the label value. Counters without a label
+// ignore it.
+func (c *counter) Inc(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.label == "" {
+		label = ""
+	}
+	c.values[label]++
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %g\n", c.name, c.values[""])
+		return
+	}
+	labels := make([]string, 0, len(c.values))
+	for label := range c.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", c.name, c.label, label, c.values[label])
+	}
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe adds a duration to the histogram.
+func (h *histogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v := d.Seconds()
+	for i, bucket := range h.buckets {
+		if v <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bucket := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, strconv.FormatFloat(bucket, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", h.name, h.sum, h.name, h.count)
+}
+
+// writeValue writes a single value of a metric collected at scrape time.
+func writeValue(w io.Writer, kind, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
+
+// metricsHandler returns a handler exposing the daemon stats, the worker
+// utilisation and the database pool stats in the prometheus text format.
+func metricsHandler(db *sql.DB, dbState *dbMonitor, jobs int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		stats.checks.write(w)
+		stats.renders.write(w)
+		stats.retries.write(w)
+		stats.responses.write(w)
+		stats.idle.write(w)
+		stats.errors.write(w)
+		stats.cache.write(w)
+		stats.circuit.write(w)
+
+		writeValue(w, "gauge", "check_graphite_workers", "Number of configured workers.", float64(jobs))
+		writeValue(w, "gauge", "check_graphite_workers_busy", "Number of workers currently running a check.", float64(stats.busy.Load()))
+
+		dbUp := 0.0
+		if dbState.up() {
+			dbUp = 1
+		}
+		writeValue(w, "gauge", "check_graphite_db_up", "Whether the workers can reach the database.", dbUp)
+		dbStats := db.Stats()
+		writeValue(w, "gauge", "check_graphite_db_open_connections", "Number of open database connections.", float64(dbStats.OpenConnections))
+		writeValue(w, "gauge", "check_graphite_db_in_use_connections", "Number of database connections in use.", float64(dbStats.InUse))
+		writeValue(w, "gauge", "check_graphite_db_idle_connections", "Number of idle database connections.", float64(dbStats.Idle))
+		writeValue(w, "counter", "check_graphite_db_wait_total", "Total number of times waited for a database connection.", float64(dbStats.WaitCount))
+		writeValue(w, "counter", "check_graphite_db_wait_duration_seconds", "Total time waited for a database connection.", dbStats.WaitDuration.Seconds())
+	})
+}
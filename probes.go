@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// workerStatus tracks the liveness of the daemon workers.
+type workerStatus struct {
+	// beats contains the time of the last loop of each worker in unix
+	// nanoseconds.
+	beats []atomic.Int64
+	// lastNext is the time of the last successful call of Next in unix
+	// nanoseconds. Finding no check counts as successful, as the database was
+	// still asked.
+	lastNext atomic.Int64
+}
+
+func newWorkerStatus(workers int) *workerStatus {
+	s := &workerStatus{beats: make([]atomic.Int64, workers)}
+	now := time.Now().UnixNano()
+	for i := range s.beats {
+		s.beats[i].Store(now)
+	}
+	s.lastNext.Store(now)
+	return s
+}
+
+// beat records that the worker is still looping.
+func (s *workerStatus) beat(worker int) {
+	s.beats[worker].Store(time.Now().UnixNano())
+}
+
+// nextDone records a successful call of Next.
+func (s *workerStatus) nextDone() {
+	s.lastNext.Store(time.Now().UnixNano())
+}
+
+// problems returns a description of every worker which did not loop and the
+// last successful Next when it is older than staleAfter.
+func (s *workerStatus) problems(staleAfter time.Duration) []string {
+	problems := []string{}
+	now := time.Now()
+	for i := range s.beats {
+		last := time.Unix(0, s.beats[i].Load())
+		if now.Sub(last) > staleAfter {
+			problems = append(problems, fmt.Sprintf("worker %d did not loop since %s", i, last.Format(time.RFC3339)))
+		}
+	}
+	last := time.Unix(0, s.lastNext.Load())
+	if now.Sub(last) > staleAfter {
+		problems = append(problems, fmt.Sprintf("no successful check since %s", last.Format(time.RFC3339)))
+	}
+	return problems
+}
+
+// healthzHandler reports if all workers are alive and checks are pulled from
+// the database.
+func healthzHandler(status *workerStatus, staleAfter time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, status.problems(staleAfter))
+	})
+}
+
+// readyzHandler reports if the database and, when configured, graphite are
+// reachable.
+func readyzHandler(db *sql.DB, client *http.Client, readyURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		problems := []string{}
+		if err := db.PingContext(ctx); err != nil {
+			problems = append(problems, fmt.Sprintf("database not reachable: %s", err))
+		}
+		if readyURL != "" {
+			if err := probeURL(ctx, client, readyURL); err != nil {
+				problems = append(problems, fmt.Sprintf("graphite not reachable: %s", err))
+			}
+		}
+		writeProbe(w, problems)
+	})
+}
+
+// probeURL requests the url and checks for a successful answer.
+func probeURL(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("answered with status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// writeProbe answers with ok or the list of problems and status 503.
+func writeProbe(w http.ResponseWriter, problems []string) {
+	w.Header().Set("Content-Type", "text/plain")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
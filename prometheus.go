@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// promMinStep is the smallest resolution requested from prometheus.
+	promMinStep = 15 * time.Second
+	// promMaxPoints is the maximum number of points prometheus returns for a
+	// single series in a range query.
+	promMaxPoints = 11000
+)
+
+type (
+	// prometheus queries the range api of a prometheus compatible server.
+	prometheus struct {
+		r       *runner
+		pool    *endpointPool
+		retries int
+	}
+
+	// promResult is the json response of the prometheus query_range api.
+	promResult struct {
+		Status    string
+		ErrorType string
+		Error     string
+		Data      struct {
+			ResultType string
+			Result     []struct {
+				Metric map[string]string
+				Values [][2]interface{}
+			}
+		}
+	}
+)
+
+func newPrometheus(r *runner, pool *endpointPool, retries int) *prometheus {
+	return &prometheus{r: r, pool: pool, retries: retries}
+}
+
+// queryURL returns the url to fetch the query over the last interval.
+func queryURL(u url.URL, query string, interval time.Duration) string {
+	end := time.Now()
+	return rangeQueryURL(u, query, end.Add(-interval), end)
+}
+
+// rangeQueryURL returns the url to fetch the query between start and end.
+func rangeQueryURL(u url.URL, query string, start, end time.Time) string {
+	step := end.Sub(start) / promMaxPoints
+	if step < promMinStep {
+		step = promMinStep
+	}
+
+	u.Path = u.Path + "/api/v1/query_range"
+	params := u.Query()
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+func (p *prometheus) Fetch(ctx context.Context, target, interval string) ([]Series, error) {
+	d, err := parseInterval(interval)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := p.pool.do(ctx, func(e *endpoint) ([]byte, error) {
+		return p.r.get(ctx, e, "prometheus", queryURL(*e.url, target, d), p.retries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseQueryRange(raw)
+}
+
+func (p *prometheus) FetchRange(ctx context.Context, target string, from, until time.Time) ([]Series, error) {
+	raw, err := p.pool.do(ctx, func(e *endpoint) ([]byte, error) {
+		return p.r.get(ctx, e, "prometheus", rangeQueryURL(*e.url, target, from, until), p.retries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseQueryRange(raw)
+}
+
+// parseQueryRange converts the json response of the query_range api into
+// series.
+func parseQueryRange(raw []byte) ([]Series, error) {
+	payload := promResult{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("could not parse json content: %s\n%s", err, raw)
+	}
+	if payload.Status != "success" {
+		return nil, fmt.Errorf("prometheus api returned %s: %s", payload.ErrorType, payload.Error)
+	}
+	if payload.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("prometheus api returned unexpected result type '%s'", payload.Data.ResultType)
+	}
+
+	series := make([]Series, len(payload.Data.Result))
+	for i, res := range payload.Data.Result {
+		series[i].Name = promSeriesName(res.Metric)
+		series[i].Tags = promTags(res.Metric)
+		series[i].Points = make([]Point, len(res.Values))
+		for j, value := range res.Values {
+			ts, ok := value[0].(float64)
+			if !ok {
+				return nil, fmt.Errorf("could not parse timestamp '%v' of %s", value[0], series[i].Name)
+			}
+			series[i].Points[j].Timestamp = int64(ts)
+			raw, ok := value[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("could not parse value '%v' of %s", value[1], series[i].Name)
+			}
+			val, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse value '%s' of %s: %s", raw, series[i].Name, err)
+			}
+			// prometheus marks missing values as NaN, so handle them like null
+			// values in graphite.
+			if val == val {
+				series[i].Points[j].Value = &val
+			}
+		}
+	}
+	return series, nil
+}
+
+// promSeriesName formats the labels of a series like prometheus does.
+func promSeriesName(metric map[string]string) string {
+	labels := make([]string, 0, len(metric))
+	for k, v := range metric {
+		if k == "__name__" {
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(labels)
+	return metric["__name__"] + "{" + strings.Join(labels, ",") + "}"
+}
+
+// promTags returns the labels of a series as tags. The metric name is
+// available as the name tag like in graphite.
+func promTags(metric map[string]string) map[string]string {
+	tags := make(map[string]string, len(metric))
+	for k, v := range metric {
+		if k == "__name__" {
+			k = "name"
+		}
+		tags[k] = v
+	}
+	return tags
+}
+
+// promOperators maps the graphite tag operators to the label matchers of
+// prometheus.
+var promOperators = map[string]string{
+	"=":   "=",
+	"!=":  "!=",
+	"=~":  "=~",
+	"!=~": "!~",
+}
+
+// TagTarget returns a series selector for the tag expressions. The name tag
+// is mapped to the metric name.
+func (p *prometheus) TagTarget(exprs []string) (string, error) {
+	matchers := make([]string, len(exprs))
+	for i, expr := range exprs {
+		tag, op, value, err := parseTagExpr(expr)
+		if err != nil {
+			return "", err
+		}
+		if tag == "name" {
+			tag = "__name__"
+		}
+		matchers[i] = fmt.Sprintf("%s%s%q", tag, promOperators[op], value)
+	}
+	return "{" + strings.Join(matchers, ",") + "}", nil
+}
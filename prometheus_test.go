@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+// promBody is a query_range response with a single series of the values.
+const promBody = `{"status":"success","data":{"resultType":"matrix","result":[
+	{"metric":{"__name__":"up","job":"node"},"values":[[1000,"1"],[1015,"NaN"],[1030,"3.5"]]}]}}`
+
+func TestParseQueryRange(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{name: "matrix", body: promBody},
+		{name: "bad json", body: `{`, err: "could not parse json content"},
+		{name: "error status", body: `{"status":"error","errorType":"bad_data","error":"parse error"}`, err: "prometheus api returned bad_data: parse error"},
+		{name: "vector", body: `{"status":"success","data":{"resultType":"vector","result":[]}}`, err: "prometheus api returned unexpected result type 'vector'"},
+		{name: "bad timestamp", body: `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[["x","1"]]}]}}`, err: "could not parse timestamp"},
+		{name: "bad value", body: `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1000,"x"]]}]}}`, err: "could not parse value"},
+		{name: "value not a string", body: `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1000,1]]}]}}`, err: "could not parse value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			series, err := parseQueryRange([]byte(test.body))
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("got error %v, expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(series) != 1 || series[0].Name != `up{job="node"}` || series[0].Tags["name"] != "up" {
+				t.Fatalf("got series %v", series)
+			}
+			points := series[0].Points
+			if len(points) != 3 || points[0].Timestamp != 1000 || *points[0].Value != 1 || points[1].Value != nil || *points[2].Value != 3.5 {
+				t.Errorf("got points %v, expected NaN to be a missing value", points)
+			}
+		})
+	}
+}
+
+func TestQueryURL(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		step     string
+	}{
+		{5 * time.Minute, "15"},
+		{110000 * time.Second, "15"},
+		{1100000 * time.Second, "100"},
+	}
+	base, _ := url.Parse("http://prom/prefix?timeout=5s")
+	for _, test := range tests {
+		u, err := url.Parse(queryURL(*base, "up", test.interval))
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+		if u.Path != "/prefix/api/v1/query_range" || query.Get("query") != "up" || query.Get("timeout") != "5s" {
+			t.Errorf("got url %s", u)
+		}
+		if time.Duration(end-start)*time.Second != test.interval || query.Get("step") != test.step {
+			t.Errorf("%s: got range %ds and step %s, expected step %s", test.interval, end-start, query.Get("step"), test.step)
+		}
+	}
+}
+
+func TestPromTagTarget(t *testing.T) {
+	tests := []struct {
+		exprs  []string
+		target string
+		err    bool
+	}{
+		{exprs: []string{"name=up"}, target: `{__name__="up"}`},
+		{exprs: []string{"name=up", "dc!=fra", "host=~web.*", "env!=~dev|test"}, target: `{__name__="up",dc!="fra",host=~"web.*",env!~"dev|test"}`},
+		{exprs: []string{`job="x"`}, target: `{job="\"x\""}`},
+		{exprs: []string{"nooperator"}, err: true},
+	}
+	p := &prometheus{}
+	for _, test := range tests {
+		target, err := p.TagTarget(test.exprs)
+		if (err != nil) != test.err || target != test.target {
+			t.Errorf("%v: got %s and error %v, expected %s", test.exprs, target, err, test.target)
+		}
+	}
+}
+
+func TestPromSeriesName(t *testing.T) {
+	tests := []struct {
+		metric map[string]string
+		name   string
+	}{
+		{map[string]string{"__name__": "up"}, "up{}"},
+		{map[string]string{"__name__": "up", "job": "node", "dc": "fra"}, `up{dc="fra",job="node"}`},
+		{map[string]string{"job": "node"}, `{job="node"}`},
+	}
+	for _, test := range tests {
+		if name := promSeriesName(test.metric); name != test.name {
+			t.Errorf("got %s, expected %s", name, test.name)
+		}
+	}
+}
+
+func TestPrometheusCheck(t *testing.T) {
+	g := newFakeGraphite(t, fakeResponse{body: promBody})
+	r := &runner{client: g.Client()}
+	command := []string{"check_graphite", "-addr", g.URL, "-backend", "prometheus", "-tags", "name=up", "-interval", "5min", "-warn", "3", "-error", "5"}
+	result := r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	if result.ExitCode != 1 || !strings.HasPrefix(result.Message, "current value: 3.500000\n") {
+		t.Errorf("got exit code %d and message %q", result.ExitCode, result.Message)
+	}
+	received := g.received()
+	if len(received) != 1 || received[0].Path != "/api/v1/query_range" || received[0].Query().Get("query") != `{__name__="up"}` {
+		t.Errorf("got requests %v", received)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+// recording is the render response of a check saved by -record. The response
+// is saved as sent by the backend and parsed again on replay.
+type recording struct {
+	Name     string          `json:"name"`
+	Time     time.Time       `json:"time"`
+	Backend  string          `json:"backend"`
+	Target   string          `json:"target"`
+	Interval string          `json:"interval"`
+	URL      string          `json:"url,omitempty"` // URL is redacted
+	Response json.RawMessage `json:"response"`
+}
+
+// record saves the response of a check into a new file in the record
+// directory. The file is named after the check and the time of the response,
+// followed by a random part, so checks sharing a name do not overwrite each
+// other.
+func record(opts *checkOptions, target string, trace *checkTrace) error {
+	rec := recording{
+		Name:     opts.metricName(),
+		Time:     time.Now().UTC(),
+		Backend:  opts.backend,
+		Target:   target,
+		Interval: opts.interval,
+	}
+	trace.mu.Lock()
+	rec.Response = trace.Body
+	if len(trace.Requests) > 0 {
+		rec.URL = trace.Requests[len(trace.Requests)-1].URL
+	}
+	trace.mu.Unlock()
+	if len(rec.Response) == 0 {
+		return fmt.Errorf("no response to record")
+	}
+
+	raw, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode recording: %s", err)
+	}
+	f, err := os.CreateTemp(opts.record, fmt.Sprintf("%s-%s-*.json", rec.Name, rec.Time.Format("20060102T150405.000Z")))
+	if err != nil {
+		return fmt.Errorf("could not create recording: %s", err)
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write recording: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write recording: %s", err)
+	}
+	return nil
+}
+
+// loadRecording reads a recording saved by record.
+func loadRecording(path string) (*recording, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read recording: %s", err)
+	}
+	rec := &recording{}
+	if err := json.Unmarshal(raw, rec); err != nil {
+		return nil, fmt.Errorf("could not parse recording %s: %s", path, err)
+	}
+	return rec, nil
+}
+
+// series parses the recorded response like the backend it was recorded from.
+func (rec *recording) series() ([]Series, error) {
+	switch rec.Backend {
+	case "", "graphite":
+		return parseRender(rec.Response)
+	case "prometheus":
+		return parseQueryRange(rec.Response)
+	default:
+		return nil, fmt.Errorf("unknown backend '%s' in recording", rec.Backend)
+	}
+}
+
+// replayRender checks the series of the recording given by -replay instead of
+// fetching them from the server.
+func replayRender(ctx context.Context, opts *checkOptions) monzero.CheckResult {
+	rec, err := loadRecording(opts.replay)
+	if err != nil {
+		return monzero.CheckResult{ExitCode: 3, Message: err.Error()}
+	}
+	series, err := rec.series()
+	if err != nil {
+		return monzero.CheckResult{ExitCode: 3, Message: err.Error()}
+	}
+	traceFrom(ctx).Target = rec.Target
+	result := renderResult(ctx, series, opts)
+	result.Message += fmt.Sprintf("replayed from a response recorded at %s\n", rec.Time.Format(time.RFC3339))
+	return result
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+)
+
+func TestRecordReplay(t *testing.T) {
+	g := newFakeGraphite(t, ok("a.b", 1.0, nil, 6.0))
+	dir := t.TempDir()
+	r := &runner{client: g.Client()}
+	command := []string{"check_graphite", "-addr", g.URL, "-key", "a.b", "-warn", "5", "-error", "10", "-record", dir}
+	recorded := r.runCheck(monzero.Check{Command: command}, context.Background())
+
+	files, err := filepath.Glob(filepath.Join(dir, "a_b-*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one recording, got %v: %v", files, err)
+	}
+	g.Close()
+
+	command = []string{"check_graphite", "-key", "a.b", "-warn", "5", "-error", "10", "-replay", files[0]}
+	replayed := r.runCheck(monzero.Check{Command: command}, context.Background())
+	if replayed.ExitCode != recorded.ExitCode {
+		t.Errorf("got exit code %d on replay, recorded %d", replayed.ExitCode, recorded.ExitCode)
+	}
+	if !strings.HasPrefix(replayed.Message, recorded.Message) || !strings.Contains(replayed.Message, "replayed from a response recorded at") {
+		t.Errorf("got message %q on replay, recorded %q", replayed.Message, recorded.Message)
+	}
+}
+
+func TestRecordKeepsResponse(t *testing.T) {
+	body := renderBody(fakeSeries{target: "a.b", values: []interface{}{1.0, 2.0}})
+	g := newFakeGraphite(t, fakeResponse{body: body})
+	dir := t.TempDir()
+	r := &runner{client: g.Client(), cache: newResponseCache(time.Minute)}
+	addr := strings.Replace(g.URL, "http://", "http://user:secret@", 1)
+	command := []string{"check_graphite", "-addr", addr, "-key", "a.b", "-name", "web/a.b", "-warn", "5", "-error", "10", "-record", dir}
+	for i := 0; i < 2; i++ {
+		r.runCheck(monzero.Check{Command: command}, context.Background())
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "web_a_b-*.json"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected two recordings, got %v: %v", files, err)
+	}
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("got permissions %o, expected 600", perm)
+	}
+	rec, err := loadRecording(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(rec.URL, "secret") || !strings.Contains(rec.URL, "user:xxxxx@") {
+		t.Errorf("expected a redacted url, got %s", rec.URL)
+	}
+	compact := &bytes.Buffer{}
+	if err := json.Compact(compact, rec.Response); err != nil || compact.String() != body {
+		t.Errorf("got response %s, expected %s", rec.Response, body)
+	}
+}
+
+func TestReplayMissingFile(t *testing.T) {
+	r := &runner{}
+	path := filepath.Join(t.TempDir(), "missing.json")
+	result := r.runCheck(monzero.Check{Command: []string{"check_graphite", "-replay", path}}, context.Background())
+	if result.ExitCode != 3 || !strings.HasPrefix(result.Message, "could not read recording") {
+		t.Errorf("got exit code %d and message %q", result.ExitCode, result.Message)
+	}
+}
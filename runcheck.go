@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"git.zero-knowledge.org/gibheer/monzero"
+	"github.com/lib/pq"
+)
+
+// runCheckByID loads the check with the id from the database, runs it like the
+// daemon would and writes the details of the run to w. When write is set, the
+// result is stored and notifications are created like a regular run does.
+func runCheckByID(db *sql.DB, id int64, r *runner, write bool, hostname string, w io.Writer) (monzero.CheckResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return monzero.CheckResult{}, fmt.Errorf("could not start database transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	query := `select cmdLine, states, mapping_id
+		from active_checks
+		where check_id = $1`
+	if write {
+		query += " for update"
+	}
+	var (
+		command   []string
+		states    []int64
+		mappingID int
+	)
+	err = tx.QueryRow(query, id).Scan(pq.Array(&command), pq.Array(&states), &mappingID)
+	if err == sql.ErrNoRows {
+		return monzero.CheckResult{}, fmt.Errorf("check %d not found", id)
+	}
+	if err != nil {
+		return monzero.CheckResult{}, fmt.Errorf("could not get check %d: %s", id, err)
+	}
+	fmt.Fprintf(w, "command: %q\n", command)
+
+	ctx, trace := withTrace(context.Background())
+	result := r.runTimed(ctx, monzero.Check{Command: command}, 30*time.Second)
+
+	// The check is parsed again only to explain the points with its levels.
+	fs := flag.NewFlagSet("check_graphite", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts := registerCheckFlags(fs)
+	if len(command) > 0 {
+		fs.Parse(command[1:])
+	}
+	trace.write(w, opts.levelWarn, opts.levelErr, true)
+
+	if !write {
+		return result, nil
+	}
+	if err := storeResult(tx, id, states, mappingID, result, hostname); err != nil {
+		return result, err
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("could not store result of check %d: %s", id, err)
+	}
+	fmt.Fprintln(w, "result stored")
+	return result, nil
+}
+
+// storeResult updates the state of the check and creates the notifications
+// in the same way as monzero.Checker.Next does.
+func storeResult(tx *sql.Tx, id int64, states []int64, mappingID int, result monzero.CheckResult, hostname string) error {
+	backToOkay := false
+	if len(states) == 0 && result.ExitCode == 0 {
+		backToOkay = true
+	} else if len(states) > 0 && states[0] > 0 && result.ExitCode == 0 {
+		backToOkay = true
+	}
+
+	if _, err := tx.Exec(`update active_checks ac
+		set next_time = now() + intval, states = ARRAY[$2::int] || states[1:4],
+				msg = $3,
+				acknowledged = case when $4 then false else acknowledged end,
+				state_since = case $2 when states[1] then state_since else now() end
+			where check_id = $1`, id, result.ExitCode, result.Message, backToOkay); err != nil {
+		return fmt.Errorf("could not update check '%d': %s", id, err)
+	}
+
+	if _, err := tx.Exec(`insert into notifications(check_id, states, output, mapping_id, notifier_id, check_host)
+			select $1, array_agg(ml.target), $2, $3, cn.notifier_id, $4
+			from active_checks ac
+			cross join lateral unnest(ac.states) s
+			join checks_notify cn on ac.check_id = cn.check_id
+			join mapping_level ml on ac.mapping_id = ml.mapping_id and s.s = ml.source
+			where ac.check_id = $1
+				and ac.acknowledged = false
+				and cn.enabled = true
+			group by cn.notifier_id;`, id, result.Message, mappingID, hostname); err != nil {
+		return fmt.Errorf("could not create notification '%d': %s", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// ServerConfig defines a named server, which checks can reference with
+// -server instead of giving the address. This keeps the credentials out of
+// the check command lines.
+type ServerConfig struct {
+	URL string `toml:"url"`
+	// Username and Password are sent as basic auth when set.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// Token is sent as bearer token when set.
+	Token   string            `toml:"token"`
+	Headers map[string]string `toml:"headers"`
+	// Insecure disables the verification of the server certificate.
+	Insecure bool `toml:"insecure"`
+	// CAFile contains additional certificates to verify the server with.
+	CAFile string `toml:"ca_file"`
+	// CertFile and KeyFile contain the client certificate to authenticate
+	// with.
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+	// Timeout is the number of seconds a single request may take.
+	Timeout int `toml:"timeout"`
+	// MaxInFlight is the number of concurrent requests to the server and
+	// RateLimit the number of requests per second. Both are unlimited when 0.
+	MaxInFlight int     `toml:"max_in_flight"`
+	RateLimit   float64 `toml:"rate_limit"`
+}
+
+// newServers returns the endpoints for the configured servers. Their clients
+// are based on the given tls config.
+func newServers(servers map[string]ServerConfig, base *tls.Config) (map[string]*endpoint, error) {
+	result := make(map[string]*endpoint, len(servers))
+	for name, cfg := range servers {
+		e, err := newServer(name, cfg, base)
+		if err != nil {
+			return nil, fmt.Errorf("could not configure server %s: %s", name, err)
+		}
+		result[name] = e
+	}
+	return result, nil
+}
+
+func newServer(name string, cfg ServerConfig, base *tls.Config) (*endpoint, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("no url given")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse url '%s': %s", cfg.URL, err)
+	}
+
+	tlsConfig := base.Clone()
+	if cfg.Insecure {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ca file: %s", err)
+		}
+		if tlsConfig.RootCAs == nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+		} else {
+			tlsConfig.RootCAs = tlsConfig.RootCAs.Clone()
+		}
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	header := http.Header{}
+	for key, value := range cfg.Headers {
+		header.Set(key, value)
+	}
+	if cfg.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(cfg.Username + ":" + cfg.Password))
+		header.Set("Authorization", "Basic "+auth)
+	}
+	if cfg.Token != "" {
+		header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	return &endpoint{
+		name:    name,
+		url:     u,
+		header:  header,
+		limiter: newRequestLimiter(cfg.MaxInFlight, cfg.RateLimit),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+		},
+	}, nil
+}
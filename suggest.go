@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// suggestOptions configures how the levels are derived from the history.
+type suggestOptions struct {
+	method string // method is either percentile or stddev
+	lower  bool   // lower is set when lower values are worse
+	// warnRate and errRate are the share of the runs which should end in a
+	// warning or an error with the percentile method.
+	warnRate float64
+	errRate  float64
+	// kWarn and kErr are the number of standard deviations from the mean with
+	// the stddev method.
+	kWarn float64
+	kErr  float64
+}
+
+// suggestLevels returns the warning and error level for the series. The
+// percentile method uses the worst value of every window, so the rates match
+// the share of runs of the check. The stddev method uses all points.
+func suggestLevels(series []Series, window, step time.Duration, sopts suggestOptions) (float64, float64, error) {
+	switch sopts.method {
+	case "percentile":
+		if sopts.warnRate <= 0 || sopts.warnRate >= 1 || sopts.errRate <= 0 || sopts.errRate >= 1 {
+			return 0, 0, fmt.Errorf("the rates must be between 0 and 1")
+		}
+		worst := []float64{}
+		eachWindow(series, window, step, func(_ time.Time, windowed []Series) {
+			// The levels only select the direction in which values are worse.
+			levelWarn, levelErr := 0.0, 1.0
+			if sopts.lower {
+				levelWarn, levelErr = 1.0, 0.0
+			}
+			if _, value := evaluate(windowed, levelWarn, levelErr); value != nil {
+				worst = append(worst, *value)
+			}
+		})
+		if len(worst) == 0 {
+			return 0, 0, fmt.Errorf("no values found in the history")
+		}
+		sort.Float64s(worst)
+		if sopts.lower {
+			return percentile(worst, sopts.warnRate), percentile(worst, sopts.errRate), nil
+		}
+		return percentile(worst, 1-sopts.warnRate), percentile(worst, 1-sopts.errRate), nil
+	case "stddev":
+		mean, stddev, count := meanStddev(series)
+		if count == 0 {
+			return 0, 0, fmt.Errorf("no values found in the history")
+		}
+		if sopts.lower {
+			return mean - sopts.kWarn*stddev, mean - sopts.kErr*stddev, nil
+		}
+		return mean + sopts.kWarn*stddev, mean + sopts.kErr*stddev, nil
+	default:
+		return 0, 0, fmt.Errorf("unknown method '%s'", sopts.method)
+	}
+}
+
+// percentile returns the value below which the share p of the sorted values
+// lies, using the nearest rank.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// meanStddev returns the mean, the standard deviation and the number of all
+// values of the series.
+func meanStddev(series []Series) (float64, float64, int) {
+	sum, count := 0.0, 0
+	for _, s := range series {
+		for _, point := range s.Points {
+			if point.Value != nil {
+				sum += *point.Value
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return 0, 0, 0
+	}
+	mean := sum / float64(count)
+	squares := 0.0
+	for _, s := range series {
+		for _, point := range s.Points {
+			if point.Value != nil {
+				squares += (*point.Value - mean) * (*point.Value - mean)
+			}
+		}
+	}
+	return mean, math.Sqrt(squares / float64(count)), count
+}
+
+// roundLevel rounds a level to six significant digits.
+func roundLevel(level float64) float64 {
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(level, 'g', 6, 64), 64)
+	if err != nil {
+		return level
+	}
+	return rounded
+}
+
+// runSuggest fetches the history of the check once, suggests levels for it
+// and writes them with the result of a backtest with these levels to w.
+func (r *runner) runSuggest(ctx context.Context, opts *checkOptions, sopts suggestOptions, span string, step time.Duration, w io.Writer) error {
+	window, err := parseInterval(opts.interval)
+	if err != nil {
+		return err
+	}
+	if step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	series, err := r.fetchHistory(ctx, opts, span)
+	if err != nil {
+		return err
+	}
+	window = historyWindow(series, window, w)
+	levelWarn, levelErr, err := suggestLevels(series, window, step, sopts)
+	if err != nil {
+		return err
+	}
+	// The levels are checked as printed, so they must be rounded the same way.
+	levelWarn, levelErr = roundLevel(levelWarn), roundLevel(levelErr)
+	if levelWarn == levelErr && sopts.lower {
+		// Equal levels make the check treat higher values as worse.
+		return fmt.Errorf("the warning and error level are both %g, which would check for higher values; choose rates or factors further apart", levelErr)
+	}
+	opts.levelWarn, opts.levelErr = levelWarn, levelErr
+	result := backtest(series, opts, window, step)
+
+	fmt.Fprintf(w, "-warn %g -error %g\n", levelWarn, levelErr)
+	if levelWarn == levelErr {
+		fmt.Fprintln(w, "the levels are equal, the check would never warn")
+	}
+	fmt.Fprintf(w, "%d evaluations from %s to %s would have resulted in\n",
+		result.Evaluations, result.From.Format(time.RFC3339), result.To.Format(time.RFC3339))
+	for state := 1; state <= 2; state++ {
+		fmt.Fprintf(w, "%s: %d times, %s in total\n", stateName(state), result.Transitions[state], result.Duration[state])
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuggestLevels(t *testing.T) {
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	series := []Series{testSeries(values...)}
+
+	tests := []struct {
+		name      string
+		series    []Series
+		sopts     suggestOptions
+		levelWarn float64
+		levelErr  float64
+	}{
+		{
+			name:      "percentile",
+			series:    series,
+			sopts:     suggestOptions{method: "percentile", warnRate: 0.1, errRate: 0.01},
+			levelWarn: 90,
+			levelErr:  99,
+		},
+		{
+			name:      "percentile of lower values",
+			series:    series,
+			sopts:     suggestOptions{method: "percentile", lower: true, warnRate: 0.1, errRate: 0.01},
+			levelWarn: 10,
+			levelErr:  1,
+		},
+		{
+			name:      "stddev",
+			series:    []Series{testSeries(2, 4, 4, 4, 5, 5, 7, 9)},
+			sopts:     suggestOptions{method: "stddev", kWarn: 2, kErr: 3},
+			levelWarn: 9,
+			levelErr:  11,
+		},
+		{
+			name:      "stddev of lower values",
+			series:    []Series{testSeries(2, 4, 4, 4, 5, 5, 7, 9)},
+			sopts:     suggestOptions{method: "stddev", lower: true, kWarn: 1, kErr: 2},
+			levelWarn: 3,
+			levelErr:  1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			levelWarn, levelErr, err := suggestLevels(test.series, time.Minute, time.Minute, test.sopts)
+			if err != nil {
+				t.Fatalf("could not suggest levels: %s", err)
+			}
+			if levelWarn != test.levelWarn || levelErr != test.levelErr {
+				t.Errorf("got levels %g and %g, expected %g and %g", levelWarn, levelErr, test.levelWarn, test.levelErr)
+			}
+		})
+	}
+}
+
+func TestSuggestLevelsWithoutValues(t *testing.T) {
+	series := []Series{testSeries(-1, -1, -1)}
+	for _, method := range []string{"percentile", "stddev"} {
+		sopts := suggestOptions{method: method, warnRate: 0.1, errRate: 0.01}
+		if _, _, err := suggestLevels(series, time.Minute, time.Minute, sopts); err == nil {
+			t.Errorf("expected an error for the %s method", method)
+		}
+	}
+}
+
+// writeRecording saves a recording of the render response body and returns
+// its path.
+func writeRecording(t *testing.T, body string) string {
+	t.Helper()
+	raw, err := json.Marshal(recording{Target: "a.b", Interval: "1min", Response: json.RawMessage(body)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "a_b.json")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunSuggest(t *testing.T) {
+	values := make([]interface{}, 100)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	path := writeRecording(t, renderBody(fakeSeries{target: "a.b", values: values}))
+	opts := &checkOptions{interval: "1min", replay: path, message: "current value: %f"}
+	sopts := suggestOptions{method: "percentile", warnRate: 0.1, errRate: 0.01}
+	report := &strings.Builder{}
+	if err := (&runner{}).runSuggest(context.Background(), opts, sopts, "1d", time.Minute, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(report.String(), "-warn 90 -error 99\n") {
+		t.Errorf("got report %q", report)
+	}
+}
+
+func TestRunSuggestLowerEqualLevels(t *testing.T) {
+	path := writeRecording(t, renderBody(fakeSeries{target: "a.b", values: []interface{}{5.0, 5.0, 5.0, 5.0}}))
+	opts := &checkOptions{interval: "1min", replay: path, message: "current value: %f"}
+	sopts := suggestOptions{method: "percentile", lower: true, warnRate: 0.1, errRate: 0.01}
+	report := &strings.Builder{}
+	err := (&runner{}).runSuggest(context.Background(), opts, sopts, "1d", time.Minute, report)
+	if err == nil || strings.Contains(report.String(), "-warn") {
+		t.Errorf("expected no levels for equal lower levels, got %q and error %v", report, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type (
+	// stringList is a flag which can be given multiple times.
+	stringList []string
+
+	// seriesGroup contains all series sharing the same values for the
+	// grouping tags.
+	seriesGroup struct {
+		Label  string
+		Series []Series
+	}
+)
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(val string) error {
+	*l = append(*l, val)
+	return nil
+}
+
+// parseTagExpr splits a tag expression like dc=fra or name=~cpu.* into the tag,
+// the operator and the value. The operators are the ones supported by
+// seriesByTag.
+func parseTagExpr(expr string) (string, string, string, error) {
+	i := strings.IndexAny(expr, "!=")
+	if i <= 0 {
+		return "", "", "", fmt.Errorf("tag expression '%s' must have the form tag=value", expr)
+	}
+	tag, rest := expr[:i], expr[i:]
+	for _, op := range []string{"!=~", "=~", "!=", "="} {
+		if strings.HasPrefix(rest, op) {
+			return tag, op, rest[len(op):], nil
+		}
+	}
+	return "", "", "", fmt.Errorf("tag expression '%s' has an unknown operator", expr)
+}
+
+// groupSeries groups the series by the values of the given tags. The groups
+// are sorted by their label.
+// Series missing a tag are grouped with an empty value for it.
+func groupSeries(series []Series, tags []string) []seriesGroup {
+	groups := map[string]*seriesGroup{}
+	for _, s := range series {
+		parts := make([]string, len(tags))
+		for i, tag := range tags {
+			parts[i] = tag + "=" + s.Tags[tag]
+		}
+		label := strings.Join(parts, ",")
+		group, found := groups[label]
+		if !found {
+			group = &seriesGroup{Label: label}
+			groups[label] = group
+		}
+		group.Series = append(group.Series, s)
+	}
+
+	result := make([]seriesGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Label < result[j].Label })
+	return result
+}
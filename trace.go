@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type (
+	// checkTrace collects the details of a single check run, which are not
+	// part of its result.
+	checkTrace struct {
+		mu sync.Mutex
+		// Value is the value the result is based on.
+		Value *float64
+		// Target is the target sent to the backend.
+		Target string
+		// Series contains the series the result is based on.
+		Series []Series
+		// Requests contains all requests sent to the backend.
+		Requests []traceRequest
+		// Endpoint is the server which answered, when more than one server
+		// was available.
+		Endpoint string
+		// Body is the body of the last successful response, which is only
+		// kept when keepBody is set.
+		Body     []byte
+		keepBody bool
+	}
+
+	// traceRequest describes a request to a backend including its retries.
+	traceRequest struct {
+		URL        string // URL is redacted, so it carries no password
+		StatusCode int    // StatusCode is 0 when no response was received
+		Attempts   int
+		Duration   time.Duration
+		Wait       time.Duration // Wait is the time spent waiting for the request limits
+		Size       int           // Size is the number of bytes of the last response
+		Cached     bool          // Cached is set when the response was shared with another check
+	}
+
+	traceKey struct{}
+)
+
+// withTrace returns a context carrying a new trace for a check run. When the
+// context already carries a trace, it is reused, so the caller can inspect
+// the trace after the run.
+func withTrace(ctx context.Context) (context.Context, *checkTrace) {
+	if trace, ok := ctx.Value(traceKey{}).(*checkTrace); ok {
+		return ctx, trace
+	}
+	trace := &checkTrace{}
+	return context.WithValue(ctx, traceKey{}, trace), trace
+}
+
+// traceFrom returns the trace of the context. When the context has no trace,
+// a new one is returned, so the caller can always record into it.
+func traceFrom(ctx context.Context) *checkTrace {
+	if trace, ok := ctx.Value(traceKey{}).(*checkTrace); ok {
+		return trace
+	}
+	return &checkTrace{}
+}
+
+// addRequest records a request sent to the backend.
+func (t *checkTrace) addRequest(req traceRequest) {
+	req.URL = redactURL(req.URL)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Requests = append(t.Requests, req)
+}
+
+// redactURL replaces the password in the url, so it can be logged. Urls which
+// can not be parsed are dropped completely, as they might still carry it.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return u.Redacted()
+}
+
+// setBody keeps the body of a successful response when the trace asks for it.
+func (t *checkTrace) setBody(body []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.keepBody {
+		t.Body = body
+	}
+}
+
+// setEndpoint records the server which answered.
+func (t *checkTrace) setEndpoint(endpoint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Endpoint = endpoint
+}
+
+// logAttrs returns the attributes of the trace for logging. The url and the
+// status code are the ones of the last request.
+func (t *checkTrace) logAttrs() []any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	attrs := []any{"target", t.Target, "requests", len(t.Requests)}
+	if t.Endpoint != "" {
+		attrs = append(attrs, "endpoint", t.Endpoint)
+	}
+	if len(t.Requests) == 0 {
+		return attrs
+	}
+	retries := 0
+	for _, req := range t.Requests {
+		if req.Attempts > 1 {
+			retries += req.Attempts - 1
+		}
+	}
+	last := t.Requests[len(t.Requests)-1]
+	return append(attrs, "url", last.URL, "status code", last.StatusCode, "retries", retries, "cached", last.Cached)
+}
+
+// maxDeciding is the number of deciding points listed per series.
+const maxDeciding = 10
+
+// write writes the requests and the series of the trace in a human readable
+// form to w. For every series, the points deciding its state are listed, or
+// all points with their state when allPoints is set.
+func (t *checkTrace) write(w io.Writer, levelWarn, levelErr float64, allPoints bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Target != "" {
+		fmt.Fprintf(w, "target: %s\n", t.Target)
+	}
+	for _, req := range t.Requests {
+		fmt.Fprintf(w, "request: %s\n", req.URL)
+		if req.Cached {
+			fmt.Fprintf(w, "\tanswered from the cache\n")
+			continue
+		}
+		fmt.Fprintf(w, "\tstatus %d, %d attempts, %d bytes in %s", req.StatusCode, req.Attempts, req.Size, req.Duration.Round(time.Millisecond))
+		if wait := req.Wait.Round(time.Millisecond); wait > 0 {
+			fmt.Fprintf(w, ", waited %s for the limits", wait)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, s := range t.Series {
+		nulls := 0
+		for _, point := range s.Points {
+			if point.Value == nil {
+				nulls++
+			}
+		}
+		state, _ := evaluate([]Series{s}, levelWarn, levelErr)
+		fmt.Fprintf(w, "series: %s, %d points, %d null, %s\n", s.Name, len(s.Points), nulls, stateName(state))
+
+		listed := 0
+		for _, point := range s.Points {
+			ts := time.Unix(point.Timestamp, 0).UTC().Format(time.RFC3339)
+			if point.Value == nil {
+				if allPoints {
+					fmt.Fprintf(w, "\t%s null\n", ts)
+				}
+				continue
+			}
+			pointState, _ := evaluate([]Series{{Points: []Point{point}}}, levelWarn, levelErr)
+			if allPoints {
+				fmt.Fprintf(w, "\t%s %g %s\n", ts, *point.Value, stateName(pointState))
+				continue
+			}
+			if state == 0 || pointState != state {
+				continue
+			}
+			if listed == maxDeciding {
+				fmt.Fprintf(w, "\tand more\n")
+				break
+			}
+			listed++
+			fmt.Fprintf(w, "\t%s %g %s\n", ts, *point.Value, stateName(pointState))
+		}
+	}
+}
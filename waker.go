@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// minSleep is the shortest time a worker sleeps, even when checks are already
+// due, as these are currently run by other workers.
+const minSleep = time.Second
+
+// waker puts idle workers to sleep until a notification about changed checks
+// arrives or, when enabled, the next check of the checker is due.
+type waker struct {
+	db        *sql.DB
+	checkerID int
+	maxWait   time.Duration
+	// sleepUntilNext shortens the sleep to the time until the next check.
+	sleepUntilNext bool
+
+	mu   sync.Mutex
+	wake chan struct{} // wake is closed and replaced on every notification
+}
+
+func newWaker(db *sql.DB, checkerID int, maxWait time.Duration, sleepUntilNext bool) *waker {
+	return &waker{
+		db:             db,
+		checkerID:      checkerID,
+		maxWait:        maxWait,
+		sleepUntilNext: sleepUntilNext,
+		wake:           make(chan struct{}),
+	}
+}
+
+// listen wakes all sleeping workers whenever a notification arrives on the
+// channel. The listener reconnects on its own after connection problems.
+func (w *waker) listen(dsn, channel string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("problem with the notification listener", "channel", channel, "error", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		// A nil notification is sent after a reconnect, when notifications
+		// may have been lost, so the workers are woken up as well.
+		for range listener.NotificationChannel() {
+			w.broadcast()
+		}
+	}()
+	return nil
+}
+
+func (w *waker) broadcast() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.wake)
+	w.wake = make(chan struct{})
+}
+
+// sleep waits until a notification arrives, the next check is due when
+// sleeping until the next check, or at most the maximum wait duration.
+func (w *waker) sleep() {
+	w.mu.Lock()
+	wake := w.wake
+	w.mu.Unlock()
+
+	wait := w.maxWait
+	if w.sleepUntilNext {
+		wait = w.untilNext()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-wake:
+	}
+}
+
+// untilNext returns the duration until the next check of the checker is due.
+func (w *waker) untilNext() time.Duration {
+	var seconds sql.NullFloat64
+	err := w.db.QueryRow(`select extract(epoch from min(next_time) - now())
+		from active_checks
+		where enabled
+			and checker_id = $1`, w.checkerID).Scan(&seconds)
+	if err != nil {
+		slog.Warn("could not get the time of the next check", "error", err)
+		return w.maxWait
+	}
+	if !seconds.Valid {
+		return w.maxWait
+	}
+	d := time.Duration(seconds.Float64 * float64(time.Second))
+	if d < minSleep {
+		return minSleep
+	}
+	if d > w.maxWait {
+		return w.maxWait
+	}
+	return d
+}
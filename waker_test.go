@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestWakerUntilNext(t *testing.T) {
+	fake := &fakeDB{queries: []fakeQuery{{
+		match:   "min(next_time)",
+		columns: []string{"seconds"},
+		rows:    [][]driver.Value{{5.0}},
+	}}}
+	w := newWaker(fake.open(t), 0, time.Minute, true)
+	if d := w.untilNext(); d != 5*time.Second {
+		t.Errorf("got %s until the next check, expected 5s", d)
+	}
+	if d := w.untilNext(); d != time.Minute {
+		t.Errorf("got %s without a next check, expected the maximum wait", d)
+	}
+}
+
+func TestWakerSleepsFullWait(t *testing.T) {
+	fake := &fakeDB{queries: []fakeQuery{{
+		match:   "min(next_time)",
+		columns: []string{"seconds"},
+		rows:    [][]driver.Value{{0.0}},
+	}}}
+	w := newWaker(fake.open(t), 0, 20*time.Millisecond, false)
+	start := time.Now()
+	w.sleep()
+	if slept := time.Since(start); slept < 20*time.Millisecond || slept >= minSleep {
+		t.Errorf("slept %s, expected the wait duration of 20ms", slept)
+	}
+	if len(fake.queries) != 1 {
+		t.Error("the time of the next check was queried without sleep_until_next")
+	}
+
+	w = newWaker(fake.open(t), 0, time.Minute, false)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		w.broadcast()
+	}()
+	start = time.Now()
+	w.sleep()
+	if slept := time.Since(start); slept >= time.Second {
+		t.Errorf("slept %s, expected to be woken up by the notification", slept)
+	}
+}